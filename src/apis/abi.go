@@ -0,0 +1,395 @@
+package apis
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jelias2/infra-test/src/keccak"
+)
+
+// ABI type kinds
+const (
+	ABIUint       = "uint"
+	ABIInt        = "int"
+	ABIAddress    = "address"
+	ABIBool       = "bool"
+	ABIFixedBytes = "fixedbytes"
+	ABIBytes      = "bytes"
+	ABIString     = "string"
+	ABIArray      = "array"
+	ABISlice      = "slice"
+	ABITuple      = "tuple"
+)
+
+// maxABIArrayLength bounds fixed size array types so a declared type can not force huge allocations
+const maxABIArrayLength = 1 << 16
+
+var ErrEmptyFunction = errors.New("function name is required")
+
+// ABIArgument is an input or output of a JSON ABI function fragment
+type ABIArgument struct {
+	Name       string        `json:"name"`
+	Type       string        `json:"type"`
+	Components []ABIArgument `json:"components,omitempty"`
+}
+
+// ABIFunction is a JSON ABI function fragment
+type ABIFunction struct {
+	Type            string        `json:"type,omitempty"`
+	Name            string        `json:"name"`
+	Inputs          []ABIArgument `json:"inputs"`
+	Outputs         []ABIArgument `json:"outputs"`
+	StateMutability string        `json:"stateMutability,omitempty"`
+}
+
+// ABIValue is a decoded output of a contract call
+type ABIValue struct {
+	Name  string      `json:"name,omitempty"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+/*
+* ABIType is a parsed Solidity ABI type. Size is the bit size of integers,
+* the byte size of fixed bytes and the length of fixed size arrays. Elem is
+* the element type of arrays and slices, Fields and Names describe tuples.
+ */
+type ABIType struct {
+	Kind   string
+	Size   int
+	Elem   *ABIType
+	Fields []ABIType
+	Names  []string
+}
+
+// ParseABIType parses a canonical type such as uint256, bytes32[] or (address,uint256)[2]
+func ParseABIType(s string) (ABIType, error) {
+	return parseABIType(strings.TrimSpace(s), nil)
+}
+
+// ABIType parses the type of the argument, tuple types take their fields from Components
+func (a ABIArgument) ABIType() (ABIType, error) {
+	return parseABIType(strings.TrimSpace(a.Type), a.Components)
+}
+
+func parseABIType(s string, components []ABIArgument) (ABIType, error) {
+	if strings.HasSuffix(s, "]") {
+		open := strings.LastIndex(s, "[")
+		if open < 0 {
+			return ABIType{}, fmt.Errorf("invalid abi type %q", s)
+		}
+		elem, err := parseABIType(s[:open], components)
+		if err != nil {
+			return ABIType{}, err
+		}
+		length := s[open+1 : len(s)-1]
+		if length == "" {
+			return ABIType{Kind: ABISlice, Elem: &elem}, nil
+		}
+		n, err := strconv.Atoi(length)
+		if err != nil || n <= 0 || n > maxABIArrayLength {
+			return ABIType{}, fmt.Errorf("invalid array length in abi type %q", s)
+		}
+		return ABIType{Kind: ABIArray, Size: n, Elem: &elem}, nil
+	}
+
+	if s == "tuple" {
+		t := ABIType{Kind: ABITuple}
+		for _, component := range components {
+			field, err := component.ABIType()
+			if err != nil {
+				return ABIType{}, err
+			}
+			t.Fields = append(t.Fields, field)
+			t.Names = append(t.Names, component.Name)
+		}
+		return t, nil
+	}
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		args, err := parseABIArguments(s[1 : len(s)-1])
+		if err != nil {
+			return ABIType{}, err
+		}
+		t := ABIType{Kind: ABITuple}
+		for _, arg := range args {
+			field, err := arg.ABIType()
+			if err != nil {
+				return ABIType{}, err
+			}
+			t.Fields = append(t.Fields, field)
+			t.Names = append(t.Names, arg.Name)
+		}
+		return t, nil
+	}
+
+	switch s {
+	case "address", "bool", "string", "bytes":
+		kind := s
+		if s == "address" {
+			kind = ABIAddress
+		}
+		return ABIType{Kind: kind}, nil
+	case "uint", "int":
+		return ABIType{Kind: s, Size: 256}, nil
+	}
+	for _, kind := range []string{ABIUint, ABIInt} {
+		if strings.HasPrefix(s, kind) {
+			bits, err := strconv.Atoi(s[len(kind):])
+			if err != nil || bits <= 0 || bits > 256 || bits%8 != 0 {
+				return ABIType{}, fmt.Errorf("invalid abi type %q", s)
+			}
+			return ABIType{Kind: kind, Size: bits}, nil
+		}
+	}
+	if strings.HasPrefix(s, "bytes") {
+		size, err := strconv.Atoi(s[len("bytes"):])
+		if err != nil || size <= 0 || size > 32 {
+			return ABIType{}, fmt.Errorf("invalid abi type %q", s)
+		}
+		return ABIType{Kind: ABIFixedBytes, Size: size}, nil
+	}
+	return ABIType{}, fmt.Errorf("unsupported abi type %q", s)
+}
+
+// String returns the canonical form of the type used in function signatures
+func (t ABIType) String() string {
+	switch t.Kind {
+	case ABIUint, ABIInt:
+		return t.Kind + strconv.Itoa(t.Size)
+	case ABIFixedBytes:
+		return "bytes" + strconv.Itoa(t.Size)
+	case ABISlice:
+		return t.Elem.String() + "[]"
+	case ABIArray:
+		return t.Elem.String() + "[" + strconv.Itoa(t.Size) + "]"
+	case ABITuple:
+		fields := make([]string, len(t.Fields))
+		for i, field := range t.Fields {
+			fields[i] = field.String()
+		}
+		return "(" + strings.Join(fields, ",") + ")"
+	}
+	return t.Kind
+}
+
+/*
+* ParseABISignature parses a human readable function signature. The outputs
+* are optional and the following forms are accepted:
+*   balanceOf(address)
+*   balanceOf(address)(uint256)
+*   function balanceOf(address owner) view returns (uint256 balance)
+ */
+func ParseABISignature(signature string) (ABIFunction, error) {
+	s := strings.TrimSpace(signature)
+	s = strings.TrimSpace(strings.TrimPrefix(s, "function "))
+	open := strings.Index(s, "(")
+	if open <= 0 {
+		return ABIFunction{}, ErrEmptyFunction
+	}
+	fn := ABIFunction{Type: "function", Name: strings.TrimSpace(s[:open])}
+	closing, err := matchingParen(s, open)
+	if err != nil {
+		return ABIFunction{}, err
+	}
+	if fn.Inputs, err = parseABIArguments(s[open+1 : closing]); err != nil {
+		return ABIFunction{}, err
+	}
+
+	rest := strings.TrimSpace(s[closing+1:])
+	for {
+		word := strings.SplitN(rest, " ", 2)[0]
+		switch word {
+		case "view", "pure", "external", "public", "payable", "nonpayable", "returns":
+			rest = strings.TrimSpace(strings.TrimPrefix(rest, word))
+			continue
+		}
+		break
+	}
+	if rest == "" {
+		return fn, nil
+	}
+	if !strings.HasPrefix(rest, "(") {
+		return ABIFunction{}, fmt.Errorf("invalid function signature %q", signature)
+	}
+	closing, err = matchingParen(rest, 0)
+	if err != nil {
+		return ABIFunction{}, err
+	}
+	if strings.TrimSpace(rest[closing+1:]) != "" {
+		return ABIFunction{}, fmt.Errorf("invalid function signature %q", signature)
+	}
+	if fn.Outputs, err = parseABIArguments(rest[1:closing]); err != nil {
+		return ABIFunction{}, err
+	}
+	return fn, nil
+}
+
+// parseABIArguments splits a comma separated argument list, each argument is a type optionally followed by a name
+func parseABIArguments(list string) ([]ABIArgument, error) {
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return nil, nil
+	}
+	var args []ABIArgument
+	depth, start := 0, 0
+	for i := 0; i <= len(list); i++ {
+		if i < len(list) {
+			switch list[i] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in %q", list)
+			}
+			if list[i] != ',' || depth > 0 {
+				continue
+			}
+		}
+		part := strings.TrimSpace(list[start:i])
+		start = i + 1
+		if part == "" {
+			return nil, fmt.Errorf("empty argument in %q", list)
+		}
+		arg, err := parseABIArgument(part)
+		if err != nil {
+			return nil, err
+		}
+		if depth != 0 {
+			return nil, fmt.Errorf("unbalanced parentheses in %q", list)
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// parseABIArgument splits "type [location] [name]", such as "(address,uint256)[] memory items"
+func parseABIArgument(part string) (ABIArgument, error) {
+	typeEnd := strings.IndexAny(part, " \t")
+	if strings.HasPrefix(part, "(") {
+		closing, err := matchingParen(part, 0)
+		if err != nil {
+			return ABIArgument{}, err
+		}
+		typeEnd = strings.IndexAny(part[closing:], " \t")
+		if typeEnd >= 0 {
+			typeEnd += closing
+		}
+	}
+	if typeEnd < 0 {
+		return ABIArgument{Type: part}, nil
+	}
+	arg := ABIArgument{Type: part[:typeEnd]}
+	for _, word := range strings.Fields(part[typeEnd:]) {
+		switch word {
+		case "memory", "calldata", "storage", "payable", "indexed":
+		default:
+			arg.Name = word
+		}
+	}
+	return arg, nil
+}
+
+func matchingParen(s string, open int) (int, error) {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unbalanced parentheses in %q", s)
+}
+
+func argumentTypes(args []ABIArgument) ([]ABIType, []string, error) {
+	types := make([]ABIType, len(args))
+	names := make([]string, len(args))
+	for i, arg := range args {
+		t, err := arg.ABIType()
+		if err != nil {
+			return nil, nil, err
+		}
+		types[i], names[i] = t, arg.Name
+	}
+	return types, names, nil
+}
+
+// OutputTypes parses the output types of the function
+func (f ABIFunction) OutputTypes() ([]ABIType, []string, error) {
+	return argumentTypes(f.Outputs)
+}
+
+// Signature returns the canonical signature such as transfer(address,uint256)
+func (f ABIFunction) Signature() (string, error) {
+	if f.Name == "" {
+		return "", ErrEmptyFunction
+	}
+	types, _, err := argumentTypes(f.Inputs)
+	if err != nil {
+		return "", err
+	}
+	return f.Name + ABIType{Kind: ABITuple, Fields: types}.String(), nil
+}
+
+// Selector returns the first four bytes of the keccak256 of the signature
+func (f ABIFunction) Selector() ([]byte, error) {
+	signature, err := f.Signature()
+	if err != nil {
+		return nil, err
+	}
+	digest := keccak.Sum256([]byte(signature))
+	return digest[:4], nil
+}
+
+// EncodeCall ABI encodes the selector and arguments of a call, args are JSON values matching the inputs
+func (f ABIFunction) EncodeCall(args []json.RawMessage) ([]byte, error) {
+	selector, err := f.Selector()
+	if err != nil {
+		return nil, err
+	}
+	types, _, err := argumentTypes(f.Inputs)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(types) {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", f.Name, len(types), len(args))
+	}
+	encoded, err := encodeABITuple(types, args)
+	if err != nil {
+		return nil, err
+	}
+	return append(selector, encoded...), nil
+}
+
+// DecodeOutputs decodes the return data of a call into the outputs of the function
+func (f ABIFunction) DecodeOutputs(data []byte) ([]ABIValue, error) {
+	types, names, err := f.OutputTypes()
+	if err != nil {
+		return nil, err
+	}
+	budget := maxABIDecodedValues
+	values, err := decodeABITuple(types, data, &budget)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make([]ABIValue, len(values))
+	for i, value := range values {
+		outputs[i] = ABIValue{Name: names[i], Type: types[i].String(), Value: value}
+	}
+	return outputs, nil
+}
+
+// HexData returns the 0x prefixed hex encoding of data
+func HexData(data []byte) string {
+	return "0x" + hex.EncodeToString(data)
+}
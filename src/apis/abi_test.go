@@ -0,0 +1,196 @@
+package apis
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestSelector(t *testing.T) {
+	for _, test := range []struct {
+		signature, canonical, selector string
+	}{
+		{"transfer(address,uint256)", "transfer(address,uint256)", "a9059cbb"},
+		{"balanceOf(address)(uint256)", "balanceOf(address)", "70a08231"},
+		{"function approve(address spender, uint256 amount) returns (bool)", "approve(address,uint256)", "095ea7b3"},
+		{"totalSupply() view returns (uint256)", "totalSupply()", "18160ddd"},
+		{"transferFrom(address from, address to, uint amount)", "transferFrom(address,address,uint256)", "23b872dd"},
+	} {
+		fn, err := ParseABISignature(test.signature)
+		if err != nil {
+			t.Fatalf("ParseABISignature(%q) error: %v", test.signature, err)
+		}
+		if canonical, _ := fn.Signature(); canonical != test.canonical {
+			t.Errorf("Signature() of %q = %s, want %s", test.signature, canonical, test.canonical)
+		}
+		selector, err := fn.Selector()
+		if err != nil || hex.EncodeToString(selector) != test.selector {
+			t.Errorf("Selector() of %q = %x, %v, want %s", test.signature, selector, err, test.selector)
+		}
+	}
+}
+
+// words joins 32 byte words given as hex, each left padded with zeros
+func words(hexWords ...string) string {
+	var b strings.Builder
+	for _, w := range hexWords {
+		b.WriteString(strings.Repeat("0", 64-len(w)) + w)
+	}
+	return b.String()
+}
+
+func TestABIRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		signature string
+		args      string
+		encoded   string
+		decoded   string
+	}{
+		{
+			name:      "static",
+			signature: "transfer(address,uint256)",
+			args:      `["0x00000000000000000000000000000000000000aa", "0x10"]`,
+			encoded:   words("aa", "10"),
+			decoded:   `["0x00000000000000000000000000000000000000aa","16"]`,
+		},
+		{
+			name:      "negative ints",
+			signature: "f(int256,int8)",
+			args:      `[-1, "-0x80"]`,
+			encoded:   words(strings.Repeat("f", 64), strings.Repeat("f", 62)+"80"),
+			decoded:   `["-1","-128"]`,
+		},
+		{
+			name:      "dynamic array",
+			signature: "f(uint256[],bool)",
+			args:      `[[1, 2], true]`,
+			encoded:   words("40", "1", "2", "1", "2"),
+			decoded:   `[["1","2"],true]`,
+		},
+		{
+			name:      "string and bytes",
+			signature: "f(string,bytes)",
+			args:      `["abc", "0x0102"]`,
+			encoded:   words("40", "80", "3", "616263"+strings.Repeat("0", 58), "2", "0102"+strings.Repeat("0", 60)),
+			decoded:   `["abc","0x0102"]`,
+		},
+		{
+			name:      "tuple",
+			signature: "f((uint256 id, string name) item)",
+			args:      `[{"id": 7, "name": "a"}]`,
+			encoded:   words("20", "7", "40", "1", "61"+strings.Repeat("0", 62)),
+			decoded:   `[{"id":"7","name":"a"}]`,
+		},
+		{
+			name:      "nested dynamic arrays",
+			signature: "f(uint8[][],bytes2[2])",
+			args:      `[[[1], []], ["0x0a0b", "0x0c0d"]]`,
+			encoded:   words("60", "0a0b"+strings.Repeat("0", 60), "0c0d"+strings.Repeat("0", 60), "2", "40", "80", "1", "1", "0"),
+			decoded:   `[[["1"],[]],["0x0a0b","0x0c0d"]]`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fn, err := ParseABISignature(test.signature)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var args []json.RawMessage
+			if err := json.Unmarshal([]byte(test.args), &args); err != nil {
+				t.Fatal(err)
+			}
+			call, err := fn.EncodeCall(args)
+			if err != nil {
+				t.Fatalf("EncodeCall() error: %v", err)
+			}
+			if got := hex.EncodeToString(call[4:]); got != test.encoded {
+				t.Errorf("EncodeCall() arguments = %s, want %s", got, test.encoded)
+			}
+
+			fn.Outputs = fn.Inputs
+			outputs, err := fn.DecodeOutputs(call[4:])
+			if err != nil {
+				t.Fatalf("DecodeOutputs() error: %v", err)
+			}
+			values := make([]interface{}, len(outputs))
+			for i, output := range outputs {
+				values[i] = output.Value
+			}
+			if got, _ := json.Marshal(values); string(got) != test.decoded {
+				t.Errorf("DecodeOutputs() = %s, want %s", got, test.decoded)
+			}
+		})
+	}
+}
+
+func TestEncodeCallRejectsOutOfRange(t *testing.T) {
+	for _, test := range []struct{ signature, args string }{
+		{"f(uint8)", `[256]`},
+		{"f(uint256)", `[-1]`},
+		{"f(int8)", `[128]`},
+		{"f(int8)", `[-129]`},
+		{"f(address)", `["0x01"]`},
+		{"f(uint256[2])", `[[1]]`},
+	} {
+		fn, err := ParseABISignature(test.signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var args []json.RawMessage
+		json.Unmarshal([]byte(test.args), &args)
+		if _, err := fn.EncodeCall(args); err == nil {
+			t.Errorf("EncodeCall(%s) of %s succeeded, want an error", test.args, test.signature)
+		}
+	}
+}
+
+/*
+* aliasedSlices encodes a uint256[]...[] of the given depth where every level
+* holds length offsets that all point at the same next level, so the input
+* grows linearly with depth while the decoded value grows as length^depth.
+ */
+func aliasedSlices(depth, length int) []byte {
+	levelSize := (length + 1) * 32
+	data := abiWord(big.NewInt(32))
+	for level := 0; level < depth; level++ {
+		data = append(data, abiWord(big.NewInt(int64(length)))...)
+		for i := 0; i < length; i++ {
+			if level == depth-1 {
+				data = append(data, abiWord(big.NewInt(int64(i)))...)
+			} else {
+				// relative to the first element of this level
+				data = append(data, abiWord(big.NewInt(int64(levelSize-32)))...)
+			}
+		}
+	}
+	return data
+}
+
+func TestDecodeOutputsBoundsAliasedOffsets(t *testing.T) {
+	for _, test := range []struct {
+		depth, length int
+		err           error
+	}{
+		{2, 64, nil},
+		{4, 64, ErrABITooLarge},
+		{8, 8, ErrABITooLarge},
+	} {
+		fn := ABIFunction{Name: "f", Outputs: []ABIArgument{{Type: "uint256" + strings.Repeat("[]", test.depth)}}}
+		_, err := fn.DecodeOutputs(aliasedSlices(test.depth, test.length))
+		if err != test.err {
+			t.Errorf("DecodeOutputs() of depth %d and length %d error = %v, want %v", test.depth, test.length, err, test.err)
+		}
+	}
+}
+
+func TestDecodeOutputsShortData(t *testing.T) {
+	fn, _ := ParseABISignature("f()(uint256,string)")
+	for _, data := range []string{"", words("1"), words("1", "40"), words("1", "40", "5")} {
+		raw, _ := hex.DecodeString(data)
+		if _, err := fn.DecodeOutputs(raw); err != ErrShortABIData {
+			t.Errorf("DecodeOutputs(%s) error = %v, want %v", data, err, ErrShortABIData)
+		}
+	}
+}
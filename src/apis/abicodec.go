@@ -0,0 +1,368 @@
+package apis
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var ErrShortABIData = errors.New("abi data is too short")
+var ErrABITooLarge = errors.New("abi data decodes to too many values")
+
+/*
+* maxABIDecodedValues bounds the values decoded from one return value. Dynamic
+* offsets may alias each other, so nested types such as uint256[][][] could
+* otherwise decode length^depth values out of a small input.
+ */
+const maxABIDecodedValues = 1 << 16
+
+var (
+	two256 = new(big.Int).Lsh(big.NewInt(1), 256)
+	maxInt = new(big.Int).Lsh(big.NewInt(1), 255)
+)
+
+// Dynamic reports whether values of the type are encoded out of place behind an offset
+func (t ABIType) Dynamic() bool {
+	switch t.Kind {
+	case ABIBytes, ABIString, ABISlice:
+		return true
+	case ABIArray:
+		return t.Elem.Dynamic()
+	case ABITuple:
+		for _, field := range t.Fields {
+			if field.Dynamic() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headSize is the number of bytes the type takes in the head of its enclosing tuple
+func (t ABIType) headSize() int {
+	if t.Dynamic() {
+		return 32
+	}
+	switch t.Kind {
+	case ABIArray:
+		return t.Size * t.Elem.headSize()
+	case ABITuple:
+		size := 0
+		for _, field := range t.Fields {
+			size += field.headSize()
+		}
+		return size
+	}
+	return 32
+}
+
+/*
+* encodeABITuple encodes values as the fields of a tuple: static values in
+* place, dynamic values appended after the head with their offset in place.
+ */
+func encodeABITuple(types []ABIType, values []json.RawMessage) ([]byte, error) {
+	if len(types) != len(values) {
+		return nil, fmt.Errorf("expected %d values, got %d", len(types), len(values))
+	}
+	headSize := 0
+	for _, t := range types {
+		headSize += t.headSize()
+	}
+	var head, tail []byte
+	for i, t := range types {
+		encoded, err := encodeABIValue(t, values[i])
+		if err != nil {
+			return nil, err
+		}
+		if t.Dynamic() {
+			head = append(head, abiWord(big.NewInt(int64(headSize+len(tail))))...)
+			tail = append(tail, encoded...)
+		} else {
+			head = append(head, encoded...)
+		}
+	}
+	return append(head, tail...), nil
+}
+
+func encodeABIValue(t ABIType, raw json.RawMessage) ([]byte, error) {
+	switch t.Kind {
+	case ABIUint, ABIInt:
+		n, err := abiInteger(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", t, err)
+		}
+		limit := new(big.Int).Lsh(big.NewInt(1), uint(t.Size))
+		if t.Kind == ABIUint && (n.Sign() < 0 || n.Cmp(limit) >= 0) {
+			return nil, fmt.Errorf("%s: %s is out of range", t, n)
+		}
+		if t.Kind == ABIInt {
+			limit.Rsh(limit, 1)
+			if n.Cmp(limit) >= 0 || n.Cmp(new(big.Int).Neg(limit)) < 0 {
+				return nil, fmt.Errorf("%s: %s is out of range", t, n)
+			}
+			if n.Sign() < 0 {
+				n.Add(n, two256)
+			}
+		}
+		return abiWord(n), nil
+	case ABIAddress:
+		data, err := abiHex(raw)
+		if err != nil || len(data) != 20 {
+			return nil, fmt.Errorf("address must be a 20 byte hex string starting with 0x")
+		}
+		return leftPad(data), nil
+	case ABIBool:
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return nil, fmt.Errorf("bool must be true or false")
+		}
+		if b {
+			return abiWord(big.NewInt(1)), nil
+		}
+		return abiWord(big.NewInt(0)), nil
+	case ABIFixedBytes:
+		data, err := abiHex(raw)
+		if err != nil || len(data) != t.Size {
+			return nil, fmt.Errorf("%s must be a %d byte hex string starting with 0x", t, t.Size)
+		}
+		return rightPad(data), nil
+	case ABIBytes:
+		data, err := abiHex(raw)
+		if err != nil {
+			return nil, fmt.Errorf("bytes must be a hex string starting with 0x")
+		}
+		return append(abiWord(big.NewInt(int64(len(data)))), rightPad(data)...), nil
+	case ABIString:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("string argument must be a JSON string")
+		}
+		return append(abiWord(big.NewInt(int64(len(s)))), rightPad([]byte(s))...), nil
+	case ABIArray, ABISlice:
+		var elems []json.RawMessage
+		if err := json.Unmarshal(raw, &elems); err != nil {
+			return nil, fmt.Errorf("%s must be a JSON array", t)
+		}
+		if t.Kind == ABIArray && len(elems) != t.Size {
+			return nil, fmt.Errorf("%s takes %d elements, got %d", t, t.Size, len(elems))
+		}
+		types := make([]ABIType, len(elems))
+		for i := range types {
+			types[i] = *t.Elem
+		}
+		encoded, err := encodeABITuple(types, elems)
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind == ABISlice {
+			encoded = append(abiWord(big.NewInt(int64(len(elems)))), encoded...)
+		}
+		return encoded, nil
+	case ABITuple:
+		values, err := tupleValues(t, raw)
+		if err != nil {
+			return nil, err
+		}
+		return encodeABITuple(t.Fields, values)
+	}
+	return nil, fmt.Errorf("unsupported abi type %q", t.Kind)
+}
+
+// tupleValues accepts a tuple as a JSON array or as an object keyed by field name
+func tupleValues(t ABIType, raw json.RawMessage) ([]json.RawMessage, error) {
+	var values []json.RawMessage
+	if json.Unmarshal(raw, &values) == nil {
+		return values, nil
+	}
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &named); err != nil {
+		return nil, fmt.Errorf("%s must be a JSON array or object", t)
+	}
+	for i, name := range t.Names {
+		value, ok := named[name]
+		if name == "" || !ok {
+			return nil, fmt.Errorf("%s is missing field %d %q", t, i, name)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// abiInteger reads a JSON number, or a decimal or 0x prefixed hex string
+func abiInteger(raw json.RawMessage) (*big.Int, error) {
+	s := strings.TrimSpace(string(raw))
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+	}
+	n, ok := new(big.Int), false
+	switch {
+	case strings.HasPrefix(s, "0x"):
+		_, ok = n.SetString(s[2:], 16)
+	case strings.HasPrefix(s, "-0x"):
+		_, ok = n.SetString(s[3:], 16)
+		n.Neg(n)
+	default:
+		_, ok = n.SetString(s, 10)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s is not an integer", s)
+	}
+	return n, nil
+}
+
+func abiHex(raw json.RawMessage) ([]byte, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(s, "0x") {
+		return nil, errors.New("hex string must start with 0x")
+	}
+	return hex.DecodeString(s[2:])
+}
+
+// abiWord encodes a non negative integer below 2^256 as a 32 byte big endian word
+func abiWord(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, 32))
+}
+
+func leftPad(data []byte) []byte {
+	word := make([]byte, 32)
+	copy(word[32-len(data):], data)
+	return word
+}
+
+func rightPad(data []byte) []byte {
+	padded := make([]byte, (len(data)+31)/32*32)
+	copy(padded, data)
+	return padded
+}
+
+// decodeABITuple decodes the fields of a tuple whose encoding starts at data[0], each value decoded is taken from budget
+func decodeABITuple(types []ABIType, data []byte, budget *int) ([]interface{}, error) {
+	if *budget -= len(types); *budget < 0 {
+		return nil, ErrABITooLarge
+	}
+	values := make([]interface{}, len(types))
+	pos := 0
+	for i, t := range types {
+		if t.Dynamic() {
+			offset, err := abiOffset(data, pos)
+			if err != nil {
+				return nil, err
+			}
+			if values[i], err = decodeABIValue(t, data[offset:], budget); err != nil {
+				return nil, err
+			}
+			pos += 32
+			continue
+		}
+		if pos > len(data) {
+			return nil, ErrShortABIData
+		}
+		value, err := decodeABIValue(t, data[pos:], budget)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+		pos += t.headSize()
+	}
+	return values, nil
+}
+
+/*
+* decodeABIValue decodes a single value whose encoding starts at data[0].
+* Integers are returned as decimal strings since they commonly exceed the
+* precision of JSON numbers, addresses and bytes as 0x prefixed hex strings.
+ */
+func decodeABIValue(t ABIType, data []byte, budget *int) (interface{}, error) {
+	switch t.Kind {
+	case ABIUint, ABIInt, ABIAddress, ABIBool, ABIFixedBytes:
+		if len(data) < 32 {
+			return nil, ErrShortABIData
+		}
+		word := data[:32]
+		switch t.Kind {
+		case ABIUint:
+			return new(big.Int).SetBytes(word).String(), nil
+		case ABIInt:
+			n := new(big.Int).SetBytes(word)
+			if n.Cmp(maxInt) >= 0 {
+				n.Sub(n, two256)
+			}
+			return n.String(), nil
+		case ABIAddress:
+			return HexData(word[12:]), nil
+		case ABIBool:
+			return word[31] != 0, nil
+		}
+		return HexData(word[:t.Size]), nil
+	case ABIBytes, ABIString:
+		length, err := abiOffset(data, 0)
+		if err != nil {
+			return nil, err
+		}
+		if 32+length > len(data) {
+			return nil, ErrShortABIData
+		}
+		if t.Kind == ABIString {
+			return string(data[32 : 32+length]), nil
+		}
+		return HexData(data[32 : 32+length]), nil
+	case ABIArray, ABISlice:
+		length := t.Size
+		if t.Kind == ABISlice {
+			var err error
+			if length, err = abiOffset(data, 0); err != nil {
+				return nil, err
+			}
+			data = data[32:]
+		}
+		// every element takes at least one word of the head
+		if length > len(data)/32 {
+			return nil, ErrShortABIData
+		}
+		if length > *budget {
+			return nil, ErrABITooLarge
+		}
+		types := make([]ABIType, length)
+		for i := range types {
+			types[i] = *t.Elem
+		}
+		return decodeABITuple(types, data, budget)
+	case ABITuple:
+		values, err := decodeABITuple(t.Fields, data, budget)
+		if err != nil {
+			return nil, err
+		}
+		named := make(map[string]interface{}, len(values))
+		for i, name := range t.Names {
+			if name == "" {
+				return values, nil
+			}
+			named[name] = values[i]
+		}
+		if len(named) != len(values) {
+			return values, nil
+		}
+		return named, nil
+	}
+	return nil, fmt.Errorf("unsupported abi type %q", t.Kind)
+}
+
+// abiOffset reads the word at pos as an offset or length, which must point inside data
+func abiOffset(data []byte, pos int) (int, error) {
+	if pos+32 > len(data) {
+		return 0, ErrShortABIData
+	}
+	n := new(big.Int).SetBytes(data[pos : pos+32])
+	if !n.IsInt64() || n.Int64() > int64(len(data)) {
+		return 0, ErrShortABIData
+	}
+	return int(n.Int64()), nil
+}
@@ -0,0 +1,27 @@
+package apis
+
+// HexResultResponse is a JSON-RPC response whose result is a single hex string
+type HexResultResponse struct {
+	Jsonrpc string    `json:"jsonrpc"`
+	Id      int       `json:"id"`
+	Result  string    `json:"result"`
+	Error   *RPCError `json:"error,omitempty"`
+}
+
+// Account is the state of an address at Block, CodeHash is the keccak256 of its code
+type Account struct {
+	Address    string `json:"address"`
+	Block      string `json:"block"`
+	Balance    string `json:"balance"`
+	Nonce      string `json:"nonce"`
+	CodeHash   string `json:"codeHash"`
+	IsContract bool   `json:"isContract"`
+}
+
+// StorageSlot is the value of a contract storage slot at Block
+type StorageSlot struct {
+	Address string `json:"address"`
+	Slot    string `json:"slot"`
+	Block   string `json:"block"`
+	Value   string `json:"value"`
+}
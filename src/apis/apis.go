@@ -1,6 +1,7 @@
 package apis
 
 import (
+	"encoding/json"
 	"net/http"
 )
 
@@ -17,42 +18,119 @@ const GetBlockByNumber RPCCall = "eth_getBlockByNumber"
 const GetLogs RPCCall = "eth_getLogs"
 const GetStorageAt RPCCall = "eth_getStorageAt"
 const GetTransactionByBlockNumberAndIndex RPCCall = "eth_getTransactionByBlockNumberAndIndex"
+const GetBlockByHash RPCCall = "eth_getBlockByHash"
+const GetTransactionByHash RPCCall = "eth_getTransactionByHash"
+const GetTransactionByBlockHashAndIndex RPCCall = "eth_getTransactionByBlockHashAndIndex"
+const GetTransactionReceipt RPCCall = "eth_getTransactionReceipt"
+const GetBalance RPCCall = "eth_getBalance"
+const GetTransactionCount RPCCall = "eth_getTransactionCount"
+const GetCode RPCCall = "eth_getCode"
+const Call RPCCall = "eth_call"
+const EstimateGas RPCCall = "eth_estimateGas"
+const FeeHistory RPCCall = "eth_feeHistory"
+const MaxPriorityFeePerGas RPCCall = "eth_maxPriorityFeePerGas"
+const ChainID RPCCall = "eth_chainId"
+const NetVersion RPCCall = "net_version"
+const Subscribe RPCCall = "eth_subscribe"
+const Unsubscribe RPCCall = "eth_unsubscribe"
+const SubscriptionNotification RPCCall = "eth_subscription"
 
-// ClientNames for map lookup
-type ClientName string
+const MalformedRequestMessage = "Malformed Request"
 
-const WsBlockNumber ClientName = "WsBlockNumber"
-const WsBlockByNumber ClientName = "WsBlockByNumber"
-const WsGasPrice ClientName = "WsGasPrice"
-const WsTxByBlockNumberAndIndex ClientName = "WsTxByBlockNumberAndIndex"
+type Healthcheck struct {
+	Status     int                  `json:"status"`
+	Message    string               `json:"message"`
+	Datetime   string               `json:"datetime"`
+	Websockets []WsConnStatus       `json:"websockets,omitempty"`
+	Upstreams  []HttpUpstreamStatus `json:"upstreams,omitempty"`
+}
 
-var AllWsClients = []ClientName{WsBlockNumber, WsBlockByNumber, WsGasPrice, WsTxByBlockNumberAndIndex}
+// WsConnStatus describes the state of a single upstream websocket connection
+type WsConnStatus struct {
+	Connection    int    `json:"connection"`
+	State         string `json:"state"`
+	Reconnects    int    `json:"reconnects"`
+	Pending       int    `json:"pending"`
+	Subscriptions int    `json:"subscriptions"`
+	ConnectedAt   string `json:"connectedAt,omitempty"`
+	LastError     string `json:"lastError,omitempty"`
+}
 
-const BooleanRequestBodyTemplate string = `{"jsonrpc":"2.0","method":"%s","params":["%s",%s],"id":1}`
-const MalformedRequestMessage = "Malformed Request"
+// HubTopicStatus describes an upstream subscription shared by local subscribers
+type HubTopicStatus struct {
+	Params       json.RawMessage `json:"params"`
+	Subscription string          `json:"subscription,omitempty"`
+	Subscribers  int             `json:"subscribers"`
+	Dropped      uint64          `json:"dropped"`
+}
 
-type Healthcheck struct {
-	Status   int    `json:"status"`
-	Message  string `json:"message"`
-	Datetime string `json:"datetime"`
+// HttpUpstreamStatus describes the health of a single upstream JSON-RPC provider
+type HttpUpstreamStatus struct {
+	Name                string        `json:"name"`
+	Weight              int           `json:"weight"`
+	Healthy             bool          `json:"healthy"`
+	LatencyMs           float64       `json:"latencyMs"`
+	Requests            uint64        `json:"requests"`
+	Failures            uint64        `json:"failures"`
+	ConsecutiveFailures int           `json:"consecutiveFailures"`
+	LastError           string        `json:"lastError,omitempty"`
+	Breaker             BreakerStatus `json:"breaker"`
+}
+
+// BreakerStatus describes the circuit breaker guarding an upstream
+type BreakerStatus struct {
+	State       string  `json:"state"`
+	FailureRate float64 `json:"failureRate"`
+	Window      int     `json:"window"`
+	Trips       int     `json:"trips"`
+	OpenedAt    string  `json:"openedAt,omitempty"`
+	RetryAt     string  `json:"retryAt,omitempty"`
+}
+
+// UpstreamsStatus is the admin view of every upstream the server talks to
+type UpstreamsStatus struct {
+	Http          []HttpUpstreamStatus `json:"http"`
+	Websockets    []WsConnStatus       `json:"websockets"`
+	Subscriptions []HubTopicStatus     `json:"subscriptions"`
 }
 
 //TODO: Refactor to use the same basic response type fot GetGas and GetBlockNumber
 type GetBlockNumberResponse struct {
-	Jsonrpc string `json:"jsonrpc"`
-	Id      int    `json:"id"`
-	Result  string `json:"result"`
+	Jsonrpc string    `json:"jsonrpc"`
+	Id      int       `json:"id"`
+	Result  string    `json:"result"`
+	Error   *RPCError `json:"error,omitempty"`
 }
 
 type GetGasPriceResponse struct {
-	Jsonrpc string `json:"jsonrpc"`
-	Id      int    `json:"id"`
-	Result  string `json:"result"`
+	Jsonrpc string    `json:"jsonrpc"`
+	Id      int       `json:"id"`
+	Result  string    `json:"result"`
+	Error   *RPCError `json:"error,omitempty"`
 }
 
+// GasPriceEvent is the data of a gasprice event on /stream/gasprice
+type GasPriceEvent struct {
+	GasPrice string `json:"gasPrice"`
+}
+
+// RPCError is the error object of a JSON-RPC 2.0 response
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// ErrorResponse is the error envelope returned by every REST and /ws route,
+// StatusCode matches the HTTP status of the response
 type ErrorResponse struct {
-	StatusCode int    `json:"statuscode"`
-	Message    string `json:"message"`
+	StatusCode int       `json:"statuscode"`
+	Message    string    `json:"message"`
+	Error      *RPCError `json:"error,omitempty"`
 }
 
 var MalformedRequestError = ErrorResponse{
@@ -61,8 +139,28 @@ var MalformedRequestError = ErrorResponse{
 }
 
 type InfuraRequestBody struct {
-	JsonRPC string   `json:"jsonrpc"`
-	Method  RPCCall  `json:"method"`
-	Params  []string `json:"params"`
-	ID      int      `json:"id"`
+	JsonRPC string            `json:"jsonrpc"`
+	Method  RPCCall           `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+	ID      int               `json:"id"`
+}
+
+// Params marshals each value into a raw JSON-RPC param, keeping its JSON type
+func Params(values ...interface{}) []json.RawMessage {
+	params := make([]json.RawMessage, 0, len(values))
+	for _, v := range values {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			raw = json.RawMessage("null")
+		}
+		params = append(params, raw)
+	}
+	return params
+}
+
+// WeiAmount is a wei quantity decoded for humans
+type WeiAmount struct {
+	Wei   string `json:"wei"`
+	Gwei  string `json:"gwei"`
+	Ether string `json:"ether"`
 }
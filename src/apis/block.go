@@ -1,64 +1,108 @@
 package apis
 
+import "encoding/json"
+
+/*
+* BlockHeader holds the header fields shared by both block representations.
+* Fields introduced by later forks are omitted when the upstream does not
+* return them: baseFeePerGas since London, withdrawals since Shanghai, blob
+* gas and the beacon root since Cancun and the requests hash since Prague.
+ */
+type BlockHeader struct {
+	Difficulty            string        `json:"difficulty"`
+	ExtraData             string        `json:"extraData"`
+	GasLimit              string        `json:"gasLimit"`
+	GasUsed               string        `json:"gasUsed"`
+	Hash                  string        `json:"hash"`
+	LogsBloom             string        `json:"logsBloom"`
+	Miner                 string        `json:"miner"`
+	MixHash               string        `json:"mixHash"`
+	Nonce                 string        `json:"nonce"`
+	Number                string        `json:"number"`
+	ParentHash            string        `json:"parentHash"`
+	ReceiptsRoot          string        `json:"receiptsRoot"`
+	Sha3Uncles            string        `json:"sha3Uncles"`
+	Size                  string        `json:"size"`
+	StateRoot             string        `json:"stateRoot"`
+	Timestamp             string        `json:"timestamp"`
+	TotalDifficulty       string        `json:"totalDifficulty,omitempty"`
+	TransactionsRoot      string        `json:"transactionsRoot"`
+	Uncles                []string      `json:"uncles"`
+	BaseFeePerGas         string        `json:"baseFeePerGas,omitempty"`
+	WithdrawalsRoot       string        `json:"withdrawalsRoot,omitempty"`
+	Withdrawals           *[]Withdrawal `json:"withdrawals,omitempty"`
+	BlobGasUsed           string        `json:"blobGasUsed,omitempty"`
+	ExcessBlobGas         string        `json:"excessBlobGas,omitempty"`
+	ParentBeaconBlockRoot string        `json:"parentBeaconBlockRoot,omitempty"`
+	RequestsHash          string        `json:"requestsHash,omitempty"`
+}
+
+// Withdrawal is a validator withdrawal included in a block since Shanghai, Amount is in gwei
+type Withdrawal struct {
+	Index          string `json:"index"`
+	ValidatorIndex string `json:"validatorIndex"`
+	Address        string `json:"address"`
+	Amount         string `json:"amount"`
+}
+
+// BlockNoTxDetails is a block with transaction hashes, Extra keeps fields this schema does not know yet
 type BlockNoTxDetails struct {
-	Difficulty       string   `json:"difficulty"`
-	ExtraData        string   `json:"extraData"`
-	GasLimit         string   `json:"gasLimit"`
-	GasUsed          string   `json:"gasUsed"`
-	Hash             string   `json:"hash"`
-	LogsBloom        string   `json:"logsBloom"`
-	Miner            string   `json:"miner"`
-	MixHash          string   `json:"mixHash"`
-	Nonce            string   `json:"nonce"`
-	Number           string   `json:"number"`
-	ParentHash       string   `json:"parentHash"`
-	ReceiptsRoot     string   `json:"receiptsRoot"`
-	Sha3Uncles       string   `json:"sha3Uncles"`
-	Size             string   `json:"size"`
-	StateRoot        string   `json:"stateRoot"`
-	Timestamp        string   `json:"timestamp"`
-	TotalDifficulty  string   `json:"totalDifficulty"`
-	Transactions     []string `json:"transactions"`
-	TransactionsRoot string   `json:"transactionsRoot"`
-	Uncles           []string `json:"uncles"`
+	BlockHeader
+	Transactions []string                   `json:"transactions"`
+	Extra        map[string]json.RawMessage `json:"-"`
 }
 
+// BlockTxDetails is a block with full transactions, Extra keeps fields this schema does not know yet
 type BlockTxDetails struct {
-	Difficulty       string        `json:"difficulty"`
-	ExtraData        string        `json:"extraData"`
-	GasLimit         string        `json:"gasLimit"`
-	GasUsed          string        `json:"gasUsed"`
-	Hash             string        `json:"hash"`
-	LogsBloom        string        `json:"logsBloom"`
-	Miner            string        `json:"miner"`
-	MixHash          string        `json:"mixHash"`
-	Nonce            string        `json:"nonce"`
-	Number           string        `json:"number"`
-	ParentHash       string        `json:"parentHash"`
-	ReceiptsRoot     string        `json:"receiptsRoot"`
-	Sha3Uncles       string        `json:"sha3Uncles"`
-	Size             string        `json:"size"`
-	StateRoot        string        `json:"stateRoot"`
-	Timestamp        string        `json:"timestamp"`
-	TotalDifficulty  string        `json:"totalDifficulty"`
-	Transactions     []Transaction `json:"transactions"`
-	TransactionsRoot string        `json:"transactionsRoot"`
-	Uncles           []string      `json:"uncles"`
+	BlockHeader
+	Transactions []Transaction              `json:"transactions"`
+	Extra        map[string]json.RawMessage `json:"-"`
+}
+
+func (b *BlockNoTxDetails) UnmarshalJSON(data []byte) error {
+	type plain BlockNoTxDetails
+	return unmarshalWithExtra(data, (*plain)(b), &b.Extra)
+}
+
+func (b BlockNoTxDetails) MarshalJSON() ([]byte, error) {
+	type plain BlockNoTxDetails
+	return marshalWithExtra(plain(b), b.Extra)
+}
+
+func (b *BlockTxDetails) UnmarshalJSON(data []byte) error {
+	type plain BlockTxDetails
+	return unmarshalWithExtra(data, (*plain)(b), &b.Extra)
+}
+
+func (b BlockTxDetails) MarshalJSON() ([]byte, error) {
+	type plain BlockTxDetails
+	return marshalWithExtra(plain(b), b.Extra)
 }
 
 type GetBlockByNumberNoTxDetailsResponse struct {
 	Jsonrpc string           `json:"jsonrpc"`
 	Id      int              `json:"id"`
 	Result  BlockNoTxDetails `json:"result"`
+	Error   *RPCError        `json:"error,omitempty"`
 }
 
 type GetBlockByNumberTxDetailsResponse struct {
 	Jsonrpc string         `json:"jsonrpc"`
 	Id      int            `json:"id"`
 	Result  BlockTxDetails `json:"result"`
+	Error   *RPCError      `json:"error,omitempty"`
 }
 
 type GetBlockByNumberRequest struct {
 	Block     string `json:"block"`
 	TxDetails string `json:"txdetails"`
 }
+
+// BlockTags are the named blocks accepted in place of a block number
+var BlockTags = map[string]bool{
+	"latest":    true,
+	"earliest":  true,
+	"pending":   true,
+	"safe":      true,
+	"finalized": true,
+}
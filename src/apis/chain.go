@@ -0,0 +1,37 @@
+package apis
+
+// BlockRef identifies a block tracked by the head follower
+type BlockRef struct {
+	Number     string `json:"number"`
+	Hash       string `json:"hash"`
+	ParentHash string `json:"parentHash,omitempty"`
+}
+
+/*
+* ReorgEvent reports a chain reorganization. Depth is how many blocks of the
+* old chain were replaced, CommonAncestor the last block both chains share.
+* Removed and Added are the hashes of the old and new blocks, oldest first.
+ */
+type ReorgEvent struct {
+	Depth          int      `json:"depth"`
+	CommonAncestor string   `json:"commonAncestor"`
+	OldHead        string   `json:"oldHead"`
+	NewHead        string   `json:"newHead"`
+	Removed        []string `json:"removed"`
+	Added          []string `json:"added"`
+	DetectedAt     string   `json:"detectedAt"`
+}
+
+/*
+* ChainHead is the chain as tracked by the head follower. Source is how new
+* heads are learned, "newHeads" while the subscription is up and "polling"
+* otherwise. Reorgs are the most recent reorganizations, newest first.
+ */
+type ChainHead struct {
+	Head      BlockRef     `json:"head"`
+	Safe      *BlockRef    `json:"safe,omitempty"`
+	Finalized *BlockRef    `json:"finalized,omitempty"`
+	Tracked   int          `json:"tracked"`
+	Source    string       `json:"source"`
+	Reorgs    []ReorgEvent `json:"reorgs"`
+}
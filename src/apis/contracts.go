@@ -0,0 +1,32 @@
+package apis
+
+import "encoding/json"
+
+/*
+* ContractCallRequest describes a read only contract call. The function is
+* given either as a human readable Function signature or as a JSON ABI
+* fragment, Args are JSON values matching its inputs in order.
+ */
+type ContractCallRequest struct {
+	Function string            `json:"function,omitempty"`
+	ABI      *ABIFunction      `json:"abi,omitempty"`
+	Args     []json.RawMessage `json:"args"`
+	Block    string            `json:"block,omitempty"`
+	From     string            `json:"from,omitempty"`
+}
+
+// CallObject is the transaction object of an eth_call request
+type CallObject struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to"`
+	Data string `json:"data"`
+}
+
+// ContractCallResponse carries the raw return data and, when the outputs are known, their decoded values
+type ContractCallResponse struct {
+	Address  string     `json:"address"`
+	Block    string     `json:"block"`
+	Function string     `json:"function"`
+	Data     string     `json:"data"`
+	Outputs  []ABIValue `json:"outputs"`
+}
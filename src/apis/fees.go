@@ -0,0 +1,67 @@
+package apis
+
+// FeeHistoryResult is the result of eth_feeHistory, the last base fee is the one of the next block
+type FeeHistoryResult struct {
+	OldestBlock       string     `json:"oldestBlock"`
+	BaseFeePerGas     []string   `json:"baseFeePerGas"`
+	GasUsedRatio      []float64  `json:"gasUsedRatio"`
+	Reward            [][]string `json:"reward"`
+	BaseFeePerBlobGas []string   `json:"baseFeePerBlobGas,omitempty"`
+	BlobGasUsedRatio  []float64  `json:"blobGasUsedRatio,omitempty"`
+}
+
+type GetFeeHistoryResponse struct {
+	Jsonrpc string           `json:"jsonrpc"`
+	Id      int              `json:"id"`
+	Result  FeeHistoryResult `json:"result"`
+	Error   *RPCError        `json:"error,omitempty"`
+}
+
+// FeeEstimate is an EIP-1559 fee suggestion
+type FeeEstimate struct {
+	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas"`
+	MaxFeePerGas         string `json:"maxFeePerGas"`
+}
+
+/*
+* FeeSuggestions are the fee estimates as of BlockNumber. BaseFeePerGas is
+* the base fee of the next block, GasPrice the legacy eth_gasPrice answer.
+ */
+type FeeSuggestions struct {
+	BlockNumber       string      `json:"blockNumber"`
+	Window            int         `json:"window"`
+	BaseFeePerGas     string      `json:"baseFeePerGas"`
+	BaseFeePerBlobGas string      `json:"baseFeePerBlobGas,omitempty"`
+	GasPrice          string      `json:"gasPrice"`
+	Slow              FeeEstimate `json:"slow"`
+	Standard          FeeEstimate `json:"standard"`
+	Fast              FeeEstimate `json:"fast"`
+}
+
+// GasPricePercentile is the effective gas price paid at a percentile of the transactions in the oracle window
+type GasPricePercentile struct {
+	Percentile float64 `json:"percentile"`
+	GasPrice   string  `json:"gasPrice"`
+}
+
+/*
+* GasOracle summarizes the effective gas prices paid by the transactions of
+* the blocks between OldestBlock and BlockNumber. Utilization is the average
+* gasUsed / gasLimit of those blocks, BaseFeePerGas the base fee of the
+* newest one. The price fields are omitted when the window has no
+* transactions.
+ */
+type GasOracle struct {
+	BlockNumber    string               `json:"blockNumber"`
+	OldestBlock    string               `json:"oldestBlock"`
+	Window         int                  `json:"window"`
+	Blocks         int                  `json:"blocks"`
+	Transactions   int                  `json:"transactions"`
+	MinGasPrice    string               `json:"minGasPrice,omitempty"`
+	MedianGasPrice string               `json:"medianGasPrice,omitempty"`
+	MaxGasPrice    string               `json:"maxGasPrice,omitempty"`
+	Percentiles    []GasPricePercentile `json:"percentiles"`
+	Utilization    float64              `json:"utilization"`
+	BaseFeePerGas  string               `json:"baseFeePerGas,omitempty"`
+	UpdatedAt      string               `json:"updatedAt"`
+}
@@ -0,0 +1,43 @@
+package apis
+
+import "encoding/json"
+
+/*
+* LogsRequest queries the event logs of a block range. Address and
+* Addresses are merged, Topics follows the eth_getLogs topic filter format.
+* FromBlock and ToBlock default to the latest block. A request continuing a
+* previous page passes its Cursor, the block range is then taken from the
+* cursor.
+ */
+type LogsRequest struct {
+	Address   string            `json:"address,omitempty"`
+	Addresses []string          `json:"addresses,omitempty"`
+	Topics    []json.RawMessage `json:"topics,omitempty"`
+	FromBlock string            `json:"fromBlock,omitempty"`
+	ToBlock   string            `json:"toBlock,omitempty"`
+	Limit     int               `json:"limit,omitempty"`
+	Cursor    string            `json:"cursor,omitempty"`
+}
+
+// LogFilter is the filter object of an eth_getLogs request
+type LogFilter struct {
+	Address   []string          `json:"address,omitempty"`
+	Topics    []json.RawMessage `json:"topics,omitempty"`
+	FromBlock string            `json:"fromBlock"`
+	ToBlock   string            `json:"toBlock"`
+}
+
+type GetLogsResponse struct {
+	JsonRPC string    `json:"jsonrpc"`
+	ID      int       `json:"id"`
+	Result  []Log     `json:"result"`
+	Error   *RPCError `json:"error,omitempty"`
+}
+
+// LogsResponse is a page of logs ordered by block and log index, NextCursor is empty on the last page
+type LogsResponse struct {
+	FromBlock  string `json:"fromBlock"`
+	ToBlock    string `json:"toBlock"`
+	Logs       []Log  `json:"logs"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
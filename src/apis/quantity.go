@@ -0,0 +1,37 @@
+package apis
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ParseQuantity parses a 0x prefixed hex quantity of any size
+func ParseQuantity(quantity string) (*big.Int, bool) {
+	if !strings.HasPrefix(quantity, "0x") {
+		return nil, false
+	}
+	return new(big.Int).SetString(quantity[2:], 16)
+}
+
+// ParseBlockNumber parses a hex or decimal block number, tags such as latest are rejected
+func ParseBlockNumber(block string) (uint64, bool) {
+	var number uint64
+	var err error
+	if strings.HasPrefix(block, "0x") {
+		number, err = strconv.ParseUint(block[2:], 16, 64)
+	} else {
+		number, err = strconv.ParseUint(block, 10, 64)
+	}
+	return number, err == nil
+}
+
+// EncodeBig formats n as a hex quantity
+func EncodeBig(n *big.Int) string {
+	return "0x" + n.Text(16)
+}
+
+// EncodeUint64 formats n as a hex quantity
+func EncodeUint64(n uint64) string {
+	return "0x" + strconv.FormatUint(n, 16)
+}
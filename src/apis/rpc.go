@@ -0,0 +1,54 @@
+package apis
+
+import "encoding/json"
+
+// AllowedMethods are the JSON-RPC methods that may be forwarded through the /rpc endpoint
+var AllowedMethods = map[RPCCall]bool{
+	GetBlockNumber:                      true,
+	GetGasPrice:                         true,
+	GetBlockByNumber:                    true,
+	GetBlockByHash:                      true,
+	GetLogs:                             true,
+	GetStorageAt:                        true,
+	GetTransactionByBlockNumberAndIndex: true,
+	GetTransactionByBlockHashAndIndex:   true,
+	GetTransactionByHash:                true,
+	GetTransactionReceipt:               true,
+	GetBalance:                          true,
+	GetTransactionCount:                 true,
+	GetCode:                             true,
+	Call:                                true,
+	EstimateGas:                         true,
+	FeeHistory:                          true,
+	MaxPriorityFeePerGas:                true,
+	ChainID:                             true,
+	NetVersion:                          true,
+}
+
+// RPCRequest is a JSON-RPC 2.0 request with its params and id kept as raw JSON
+type RPCRequest struct {
+	JsonRPC string          `json:"jsonrpc"`
+	Method  RPCCall         `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// RPCResponse is a JSON-RPC 2.0 response with its result and id kept as raw JSON
+type RPCResponse struct {
+	JsonRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCNotification is an eth_subscription message pushed to a subscribed websocket client
+type RPCNotification struct {
+	JsonRPC string             `json:"jsonrpc"`
+	Method  RPCCall            `json:"method"`
+	Params  SubscriptionResult `json:"params"`
+}
+
+type SubscriptionResult struct {
+	Subscription string          `json:"subscription"`
+	Result       json.RawMessage `json:"result"`
+}
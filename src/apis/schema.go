@@ -0,0 +1,103 @@
+package apis
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Forks distinguished by the block header fields they introduced
+const (
+	ForkFrontier = "frontier"
+	ForkLondon   = "london"
+	ForkShanghai = "shanghai"
+	ForkCancun   = "cancun"
+	ForkPrague   = "prague"
+)
+
+// Fork returns the latest fork whose header fields are present in the block
+func (b *BlockHeader) Fork() string {
+	switch {
+	case b.RequestsHash != "":
+		return ForkPrague
+	case b.ParentBeaconBlockRoot != "" || b.BlobGasUsed != "" || b.ExcessBlobGas != "":
+		return ForkCancun
+	case b.WithdrawalsRoot != "" || b.Withdrawals != nil:
+		return ForkShanghai
+	case b.BaseFeePerGas != "":
+		return ForkLondon
+	}
+	return ForkFrontier
+}
+
+// knownFields caches the JSON keys of each struct type, including those of embedded structs
+var knownFields sync.Map
+
+func jsonKeys(t reflect.Type) map[string]bool {
+	if keys, ok := knownFields.Load(t); ok {
+		return keys.(map[string]bool)
+	}
+	keys := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if field.Anonymous && tag == "" {
+			for key := range jsonKeys(field.Type) {
+				keys[key] = true
+			}
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		keys[name] = true
+	}
+	knownFields.Store(t, keys)
+	return keys
+}
+
+// unmarshalWithExtra decodes data into v, a pointer to a struct, and keeps the keys v has no field for in extra
+func unmarshalWithExtra(data []byte, v interface{}, extra *map[string]json.RawMessage) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	known := jsonKeys(reflect.TypeOf(v).Elem())
+	*extra = nil
+	for key, value := range fields {
+		if known[key] {
+			continue
+		}
+		if *extra == nil {
+			*extra = make(map[string]json.RawMessage)
+		}
+		(*extra)[key] = value
+	}
+	return nil
+}
+
+// marshalWithExtra encodes v and adds the extra keys to the resulting object
+func marshalWithExtra(v interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+	}
+	return json.Marshal(fields)
+}
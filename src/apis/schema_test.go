@@ -0,0 +1,144 @@
+package apis
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// checkExtra asserts that extra holds exactly the unknown keys of fixture and that encoded returns them byte for byte
+func checkExtra(t *testing.T, fixture []byte, extra map[string]json.RawMessage, encoded []byte, unknown ...string) {
+	t.Helper()
+	var in, out map[string]json.RawMessage
+	if err := json.Unmarshal(fixture, &in); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(encoded, &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(extra) != len(unknown) {
+		t.Errorf("Extra has %d keys, want %v", len(extra), unknown)
+	}
+	for _, key := range unknown {
+		if !bytes.Equal(extra[key], in[key]) {
+			t.Errorf("Extra[%q] = %s, want %s", key, extra[key], in[key])
+		}
+		if !bytes.Equal(out[key], in[key]) {
+			t.Errorf("re-encoded %q = %s, want %s", key, out[key], in[key])
+		}
+	}
+}
+
+func TestTransactionFixtures(t *testing.T) {
+	for _, test := range []struct {
+		fixture string
+		txType  string
+	}{
+		{"tx_legacy.json", LegacyTxType},
+		{"tx_access_list.json", AccessListTxType},
+		{"tx_dynamic_fee.json", DynamicFeeTxType},
+		{"tx_blob.json", BlobTxType},
+		{"tx_set_code.json", SetCodeTxType},
+	} {
+		t.Run(test.fixture, func(t *testing.T) {
+			fixture := readFixture(t, test.fixture)
+			var tx Transaction
+			if err := json.Unmarshal(fixture, &tx); err != nil {
+				t.Fatal(err)
+			}
+			if got := tx.TxType(); got != test.txType {
+				t.Errorf("TxType() = %s, want %s", got, test.txType)
+			}
+			encoded, err := json.Marshal(tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkExtra(t, fixture, tx.Extra, encoded, "futureTxField")
+		})
+	}
+}
+
+func TestReceiptFixtures(t *testing.T) {
+	for _, test := range []struct {
+		fixture string
+		txType  string
+	}{
+		{"receipt_legacy.json", LegacyTxType},
+		{"receipt_access_list.json", AccessListTxType},
+		{"receipt_dynamic_fee.json", DynamicFeeTxType},
+		{"receipt_blob.json", BlobTxType},
+		{"receipt_set_code.json", SetCodeTxType},
+	} {
+		t.Run(test.fixture, func(t *testing.T) {
+			fixture := readFixture(t, test.fixture)
+			var receipt Receipt
+			if err := json.Unmarshal(fixture, &receipt); err != nil {
+				t.Fatal(err)
+			}
+			if got := receipt.TxType(); got != test.txType {
+				t.Errorf("TxType() = %s, want %s", got, test.txType)
+			}
+			encoded, err := json.Marshal(receipt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkExtra(t, fixture, receipt.Extra, encoded, "l1Fee", "futureReceiptField")
+		})
+	}
+}
+
+func TestBlockFixtures(t *testing.T) {
+	for _, test := range []struct {
+		fixture string
+		fork    string
+	}{
+		{"block_london.json", ForkLondon},
+		{"block_shanghai.json", ForkShanghai},
+		{"block_cancun.json", ForkCancun},
+	} {
+		t.Run(test.fixture, func(t *testing.T) {
+			fixture := readFixture(t, test.fixture)
+			var block BlockTxDetails
+			if err := json.Unmarshal(fixture, &block); err != nil {
+				t.Fatal(err)
+			}
+			if got := block.Fork(); got != test.fork {
+				t.Errorf("Fork() = %s, want %s", got, test.fork)
+			}
+			if len(block.Transactions) != 1 {
+				t.Fatalf("got %d transactions, want 1", len(block.Transactions))
+			}
+			if got := block.Transactions[0].TxType(); got != DynamicFeeTxType {
+				t.Errorf("transaction TxType() = %s, want %s", got, DynamicFeeTxType)
+			}
+			encoded, err := json.Marshal(block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkExtra(t, fixture, block.Extra, encoded, "futureHeaderField")
+
+			var in, out struct {
+				Transactions []json.RawMessage `json:"transactions"`
+			}
+			if err := json.Unmarshal(fixture, &in); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(encoded, &out); err != nil {
+				t.Fatal(err)
+			}
+			checkExtra(t, in.Transactions[0], block.Transactions[0].Extra, out.Transactions[0], "futureTxField")
+
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package apis
+
+/*
+* ChainStats summarizes the blocks between OldestBlock and BlockNumber.
+* AverageBlockTime is in seconds, GasUsedRatio the average gasUsed / gasLimit
+* and BaseFeeChange the percentage the base fee moved from the oldest to the
+* newest block. The base fee fields are omitted before London.
+ */
+type ChainStats struct {
+	BlockNumber           string  `json:"blockNumber"`
+	OldestBlock           string  `json:"oldestBlock"`
+	Blocks                int     `json:"blocks"`
+	AverageBlockTime      float64 `json:"averageBlockTime"`
+	Transactions          int     `json:"transactions"`
+	TransactionsPerSecond float64 `json:"transactionsPerSecond"`
+	GasUsedRatio          float64 `json:"gasUsedRatio"`
+	BaseFeePerGas         string  `json:"baseFeePerGas,omitempty"`
+	OldestBaseFeePerGas   string  `json:"oldestBaseFeePerGas,omitempty"`
+	BaseFeeChange         float64 `json:"baseFeeChange"`
+	BaseFeeTrend          string  `json:"baseFeeTrend,omitempty"`
+	Uncles                int     `json:"uncles"`
+	EmptyBlocks           int     `json:"emptyBlocks"`
+}
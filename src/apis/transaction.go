@@ -1,20 +1,82 @@
 package apis
 
+import "encoding/json"
+
+// Transaction types
+const (
+	LegacyTxType     = "0x0"
+	AccessListTxType = "0x1"
+	DynamicFeeTxType = "0x2"
+	BlobTxType       = "0x3"
+	SetCodeTxType    = "0x4"
+)
+
+/*
+* Transaction covers every transaction type. Fields a type does not define
+* are omitted: chainId is optional for legacy transactions, the access list
+* arrives with EIP-2930, the fee caps with EIP-1559, blob fields with
+* EIP-4844 and the authorization list with EIP-7702. Extra keeps fields this
+* schema does not know yet.
+ */
 type Transaction struct {
-	BlockHash        string `json:"blockHash"`
-	BlockNumber      string `json:"blockNumber"`
-	From             string `json:"from"`
-	Gas              string `json:"gas"`
-	GasPrice         string `json:"gasPrice"`
-	Hash             string `json:"hash"`
-	Input            string `json:"input"`
-	Nonce            string `json:"nonce"`
-	R                string `json:"r"`
-	S                string `json:"s"`
-	To               string `json:"to"`
-	TransactionIndex string `json:"transactionIndex"`
-	V                string `json:"v"`
-	Value            string `json:"value"`
+	BlockHash            string                     `json:"blockHash"`
+	BlockNumber          string                     `json:"blockNumber"`
+	From                 string                     `json:"from"`
+	Gas                  string                     `json:"gas"`
+	GasPrice             string                     `json:"gasPrice"`
+	Hash                 string                     `json:"hash"`
+	Input                string                     `json:"input"`
+	Nonce                string                     `json:"nonce"`
+	R                    string                     `json:"r"`
+	S                    string                     `json:"s"`
+	To                   string                     `json:"to"`
+	TransactionIndex     string                     `json:"transactionIndex"`
+	V                    string                     `json:"v"`
+	Value                string                     `json:"value"`
+	Type                 string                     `json:"type,omitempty"`
+	ChainID              string                     `json:"chainId,omitempty"`
+	YParity              string                     `json:"yParity,omitempty"`
+	AccessList           *[]AccessTuple             `json:"accessList,omitempty"`
+	MaxFeePerGas         string                     `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas string                     `json:"maxPriorityFeePerGas,omitempty"`
+	MaxFeePerBlobGas     string                     `json:"maxFeePerBlobGas,omitempty"`
+	BlobVersionedHashes  []string                   `json:"blobVersionedHashes,omitempty"`
+	AuthorizationList    *[]Authorization           `json:"authorizationList,omitempty"`
+	Extra                map[string]json.RawMessage `json:"-"`
+}
+
+// AccessTuple is an entry of an EIP-2930 access list
+type AccessTuple struct {
+	Address     string   `json:"address"`
+	StorageKeys []string `json:"storageKeys"`
+}
+
+// Authorization is an entry of an EIP-7702 authorization list
+type Authorization struct {
+	ChainID string `json:"chainId"`
+	Address string `json:"address"`
+	Nonce   string `json:"nonce"`
+	YParity string `json:"yParity"`
+	R       string `json:"r"`
+	S       string `json:"s"`
+}
+
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	type plain Transaction
+	return unmarshalWithExtra(data, (*plain)(t), &t.Extra)
+}
+
+func (t Transaction) MarshalJSON() ([]byte, error) {
+	type plain Transaction
+	return marshalWithExtra(plain(t), t.Extra)
+}
+
+// TxType returns the transaction type, transactions from before EIP-2718 have none and are legacy
+func (t *Transaction) TxType() string {
+	if t.Type == "" {
+		return LegacyTxType
+	}
+	return t.Type
 }
 
 type GetTransactionByBlockNumberAndIndexRequest struct {
@@ -26,4 +88,69 @@ type GetTransactionByBlockNumberAndIndexResponse struct {
 	Jsonrpc string      `json:"jsonrpc"`
 	Id      int         `json:"id"`
 	Result  Transaction `json:"result"`
+	Error   *RPCError   `json:"error,omitempty"`
+}
+
+type Receipt struct {
+	BlockHash         string                     `json:"blockHash"`
+	BlockNumber       string                     `json:"blockNumber"`
+	ContractAddress   string                     `json:"contractAddress"`
+	CumulativeGasUsed string                     `json:"cumulativeGasUsed"`
+	EffectiveGasPrice string                     `json:"effectiveGasPrice"`
+	From              string                     `json:"from"`
+	GasUsed           string                     `json:"gasUsed"`
+	Logs              []Log                      `json:"logs"`
+	LogsBloom         string                     `json:"logsBloom"`
+	Status            string                     `json:"status"`
+	To                string                     `json:"to"`
+	TransactionHash   string                     `json:"transactionHash"`
+	TransactionIndex  string                     `json:"transactionIndex"`
+	Type              string                     `json:"type"`
+	BlobGasUsed       string                     `json:"blobGasUsed,omitempty"`
+	BlobGasPrice      string                     `json:"blobGasPrice,omitempty"`
+	Extra             map[string]json.RawMessage `json:"-"`
+}
+
+func (r *Receipt) UnmarshalJSON(data []byte) error {
+	type plain Receipt
+	return unmarshalWithExtra(data, (*plain)(r), &r.Extra)
+}
+
+func (r Receipt) MarshalJSON() ([]byte, error) {
+	type plain Receipt
+	return marshalWithExtra(plain(r), r.Extra)
+}
+
+// TxType returns the type of the receipt's transaction, receipts from before EIP-2718 have none and are legacy
+func (r *Receipt) TxType() string {
+	if r.Type == "" {
+		return LegacyTxType
+	}
+	return r.Type
+}
+
+type Log struct {
+	Address          string   `json:"address"`
+	Topics           []string `json:"topics"`
+	Data             string   `json:"data"`
+	BlockNumber      string   `json:"blockNumber"`
+	BlockHash        string   `json:"blockHash"`
+	TransactionHash  string   `json:"transactionHash"`
+	TransactionIndex string   `json:"transactionIndex"`
+	LogIndex         string   `json:"logIndex"`
+	Removed          bool     `json:"removed"`
+}
+
+type GetTransactionByHashResponse struct {
+	Jsonrpc string      `json:"jsonrpc"`
+	Id      int         `json:"id"`
+	Result  Transaction `json:"result"`
+	Error   *RPCError   `json:"error,omitempty"`
+}
+
+type GetTransactionReceiptResponse struct {
+	Jsonrpc string    `json:"jsonrpc"`
+	Id      int       `json:"id"`
+	Result  Receipt   `json:"result"`
+	Error   *RPCError `json:"error,omitempty"`
 }
@@ -0,0 +1,50 @@
+package apis
+
+// WebhookRequest registers a webhook for transactions from or to any of Addresses
+type WebhookRequest struct {
+	URL       string   `json:"url"`
+	Addresses []string `json:"addresses"`
+	Token     string   `json:"token,omitempty"`
+	Secret    string   `json:"secret,omitempty"`
+}
+
+// Webhook is a registered webhook, Secret is only returned when it is created
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Addresses []string `json:"addresses"`
+	Token     string   `json:"token,omitempty"`
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt string   `json:"createdAt"`
+}
+
+/*
+* WebhookPayload is the body POSTed to a webhook. Event is "block" for a
+* block with matching transactions and "orphaned" when a block delivered
+* before was replaced by a reorg, with the transactions delivered for it.
+ */
+type WebhookPayload struct {
+	WebhookID    string        `json:"webhookId"`
+	DeliveryID   string        `json:"deliveryId"`
+	Event        string        `json:"event"`
+	BlockNumber  string        `json:"blockNumber"`
+	BlockHash    string        `json:"blockHash"`
+	Timestamp    string        `json:"timestamp"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// WebhookDelivery is an entry of the delivery log of a webhook
+type WebhookDelivery struct {
+	ID           string   `json:"id"`
+	WebhookID    string   `json:"webhookId"`
+	Event        string   `json:"event"`
+	BlockNumber  string   `json:"blockNumber"`
+	BlockHash    string   `json:"blockHash"`
+	Transactions []string `json:"transactions"`
+	Status       string   `json:"status"`
+	Attempts     int      `json:"attempts"`
+	StatusCode   int      `json:"statuscode,omitempty"`
+	LastError    string   `json:"lastError,omitempty"`
+	CreatedAt    string   `json:"createdAt"`
+	UpdatedAt    string   `json:"updatedAt"`
+}
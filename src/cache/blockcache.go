@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const DefaultMaxBytes = 64 << 20
+const DefaultConfirmations = 12
+
+// entryOverhead approximates the bookkeeping cost of an entry on top of its body
+const entryOverhead = 64
+
+type blockKey struct {
+	number    uint64
+	txdetails bool
+}
+
+type blockEntry struct {
+	key  blockKey
+	body []byte
+}
+
+/*
+* BlockCache is a size bounded LRU of raw eth_getBlockByNumber responses keyed
+* by block number and txdetails flag. Only blocks at least Confirmations deep
+* behind the last observed chain head are admitted, since those are treated as
+* final and never change.
+ */
+type BlockCache struct {
+	MaxBytes      int64
+	Confirmations uint64
+
+	mu     sync.Mutex
+	ll     *list.List
+	items  map[blockKey]*list.Element
+	size   int64
+	head   uint64
+	headAt time.Time
+}
+
+func NewBlockCache(maxBytes int64, confirmations uint64) *BlockCache {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	return &BlockCache{
+		MaxBytes:      maxBytes,
+		Confirmations: confirmations,
+		ll:            list.New(),
+		items:         make(map[blockKey]*list.Element),
+	}
+}
+
+// Get returns the cached response for a block and marks it as recently used
+func (c *BlockCache) Get(number uint64, txdetails bool) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[blockKey{number, txdetails}]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*blockEntry).body, true
+}
+
+// Add stores body for a block if it is deep enough behind the head, evicting the least recently used blocks to fit
+func (c *BlockCache) Add(number uint64, txdetails bool, body []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	size := int64(len(body)) + entryOverhead
+	if !c.final(number) || size > c.MaxBytes {
+		return false
+	}
+	key := blockKey{number, txdetails}
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		return true
+	}
+	c.items[key] = c.ll.PushFront(&blockEntry{key: key, body: body})
+	c.size += size
+	for c.size > c.MaxBytes {
+		c.removeElement(c.ll.Back())
+	}
+	return true
+}
+
+// Invalidate removes every cached block numbered from or higher, it is called when those blocks were reorganized
+func (c *BlockCache) Invalidate(from uint64) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for key, el := range c.items {
+		if key.number >= from {
+			c.removeElement(el)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Final reports whether a block is deep enough behind the last observed head to be cached
+func (c *BlockCache) Final(number uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.final(number)
+}
+
+// ObserveHead records the latest known chain head
+func (c *BlockCache) ObserveHead(number uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if number >= c.head {
+		c.head = number
+		c.headAt = time.Now()
+	}
+}
+
+// Head returns the last observed chain head and when it was observed
+func (c *BlockCache) Head() (uint64, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.head, c.headAt
+}
+
+func (c *BlockCache) final(number uint64) bool {
+	return c.head >= c.Confirmations && number <= c.head-c.Confirmations
+}
+
+func (c *BlockCache) removeElement(el *list.Element) {
+	entry := el.Value.(*blockEntry)
+	c.ll.Remove(el)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.body)) + entryOverhead
+}
@@ -0,0 +1,199 @@
+package chain
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+const DefaultDepth = 128
+
+// MaxDepth bounds how many block hashes are kept
+const MaxDepth = 4096
+
+// maxReorgs is how many reorganizations are remembered for /chain/head
+const maxReorgs = 16
+
+// How the tracker learns about new heads
+const (
+	SourceSubscription = "newHeads"
+	SourcePolling      = "polling"
+)
+
+// Header is what the tracker keeps of a block
+type Header struct {
+	Number     uint64
+	Hash       string
+	ParentHash string
+}
+
+// Ref is the header as reported by /chain/head
+func (h Header) Ref() *apis.BlockRef {
+	return &apis.BlockRef{Number: apis.EncodeUint64(h.Number), Hash: h.Hash, ParentHash: h.ParentHash}
+}
+
+/*
+* Tracker keeps the hashes of the last Depth canonical blocks in a ring
+* buffer together with the safe and finalized blocks. Branches applied to it
+* that replace tracked blocks are reported as reorgs to every listener
+* registered with OnReorg.
+ */
+type Tracker struct {
+	Depth int
+
+	mu        sync.Mutex
+	headers   []Header
+	safe      *Header
+	finalized *Header
+	source    string
+	reorgs    []apis.ReorgEvent
+	listeners []func(apis.ReorgEvent)
+}
+
+func NewTracker(depth int) *Tracker {
+	if depth <= 0 {
+		depth = DefaultDepth
+	}
+	if depth > MaxDepth {
+		depth = MaxDepth
+	}
+	return &Tracker{Depth: depth, source: SourcePolling}
+}
+
+// OnReorg registers listener to be called with every reorg detected from now on
+func (t *Tracker) OnReorg(listener func(apis.ReorgEvent)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.listeners = append(t.listeners, listener)
+}
+
+// Head returns the newest tracked block
+func (t *Tracker) Head() (Header, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.headers) == 0 {
+		return Header{}, false
+	}
+	return t.headers[len(t.headers)-1], true
+}
+
+// Get returns the tracked block at number
+func (t *Tracker) Get(number uint64) (Header, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.get(number)
+}
+
+func (t *Tracker) get(number uint64) (Header, bool) {
+	i := sort.Search(len(t.headers), func(i int) bool { return t.headers[i].Number >= number })
+	if i == len(t.headers) || t.headers[i].Number != number {
+		return Header{}, false
+	}
+	return t.headers[i], true
+}
+
+// Known reports whether a block is tracked with this hash
+func (t *Tracker) Known(number uint64, hash string) bool {
+	header, ok := t.Get(number)
+	return ok && header.Hash == hash
+}
+
+/*
+* Apply adds branch, consecutive blocks oldest first whose first block
+* builds on a tracked block or on one older than the ring. Blocks already
+* tracked with the same hash are skipped. When the branch replaces tracked
+* blocks the reorg is recorded, sent to the listeners and returned.
+ */
+func (t *Tracker) Apply(branch []Header) *apis.ReorgEvent {
+	t.mu.Lock()
+	for len(branch) > 0 {
+		if known, ok := t.get(branch[0].Number); !ok || known.Hash != branch[0].Hash {
+			break
+		}
+		branch = branch[1:]
+	}
+	if len(branch) == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+
+	keep := len(t.headers)
+	for keep > 0 && t.headers[keep-1].Number >= branch[0].Number {
+		keep--
+	}
+	replaced := append([]Header(nil), t.headers[keep:]...)
+	t.headers = append(t.headers[:keep], branch...)
+	if len(t.headers) > t.Depth {
+		t.headers = append([]Header(nil), t.headers[len(t.headers)-t.Depth:]...)
+	}
+	if len(replaced) == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+
+	reorg := apis.ReorgEvent{
+		Depth:          len(replaced),
+		CommonAncestor: apis.EncodeUint64(branch[0].Number - 1),
+		OldHead:        replaced[len(replaced)-1].Hash,
+		NewHead:        branch[len(branch)-1].Hash,
+		DetectedAt:     time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, header := range replaced {
+		reorg.Removed = append(reorg.Removed, header.Hash)
+	}
+	for _, header := range branch {
+		reorg.Added = append(reorg.Added, header.Hash)
+	}
+	t.reorgs = append(t.reorgs, reorg)
+	if len(t.reorgs) > maxReorgs {
+		t.reorgs = t.reorgs[len(t.reorgs)-maxReorgs:]
+	}
+	listeners := t.listeners
+	t.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(reorg)
+	}
+	return &reorg
+}
+
+// SetCheckpoints records the latest safe and finalized blocks, nil when the upstream does not know them
+func (t *Tracker) SetCheckpoints(safe, finalized *Header) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.safe, t.finalized = safe, finalized
+}
+
+// SetSource records how new heads are currently learned
+func (t *Tracker) SetSource(source string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.source = source
+}
+
+// Status reports the tracked head, checkpoints and recent reorgs, it fails until a block was applied
+func (t *Tracker) Status() (*apis.ChainHead, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.headers) == 0 {
+		return nil, false
+	}
+	status := &apis.ChainHead{
+		Head:    *t.headers[len(t.headers)-1].Ref(),
+		Tracked: len(t.headers),
+		Source:  t.source,
+		Reorgs:  []apis.ReorgEvent{},
+	}
+	if t.safe != nil {
+		status.Safe = t.safe.Ref()
+	}
+	if t.finalized != nil {
+		status.Finalized = t.finalized.Ref()
+	}
+	for i := len(t.reorgs) - 1; i >= 0; i-- {
+		status.Reorgs = append(status.Reorgs, t.reorgs[i])
+	}
+	return status, true
+}
@@ -0,0 +1,116 @@
+package chain
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+// headers builds consecutive headers from..to whose hashes carry the fork name
+func headers(fork string, from, to uint64) []Header {
+	var branch []Header
+	for n := from; n <= to; n++ {
+		branch = append(branch, Header{Number: n, Hash: fmt.Sprintf("0x%s%d", fork, n), ParentHash: fmt.Sprintf("0x%s%d", fork, n-1)})
+	}
+	return branch
+}
+
+func TestApplyExtendsChain(t *testing.T) {
+	tracker := NewTracker(8)
+	if reorg := tracker.Apply(headers("a", 1, 3)); reorg != nil {
+		t.Fatalf("Apply() = %+v, want no reorg", reorg)
+	}
+	if reorg := tracker.Apply(headers("a", 3, 5)); reorg != nil {
+		t.Fatalf("Apply() of already tracked blocks = %+v, want no reorg", reorg)
+	}
+	head, ok := tracker.Head()
+	if !ok || head.Hash != "0xa5" {
+		t.Fatalf("Head() = %+v, %v, want 0xa5", head, ok)
+	}
+	if !tracker.Known(2, "0xa2") || tracker.Known(2, "0xb2") {
+		t.Error("Known() does not match the tracked hashes")
+	}
+}
+
+func TestApplyReportsReorg(t *testing.T) {
+	tracker := NewTracker(8)
+	var heard []apis.ReorgEvent
+	tracker.OnReorg(func(reorg apis.ReorgEvent) { heard = append(heard, reorg) })
+	tracker.Apply(headers("a", 1, 5))
+
+	reorg := tracker.Apply(headers("b", 4, 6))
+	if reorg == nil {
+		t.Fatal("Apply() of a competing branch reported no reorg")
+	}
+	want := apis.ReorgEvent{
+		Depth:          2,
+		CommonAncestor: "0x3",
+		OldHead:        "0xa5",
+		NewHead:        "0xb6",
+		Removed:        []string{"0xa4", "0xa5"},
+		Added:          []string{"0xb4", "0xb5", "0xb6"},
+		DetectedAt:     reorg.DetectedAt,
+	}
+	if !reflect.DeepEqual(*reorg, want) {
+		t.Errorf("Apply() = %+v, want %+v", *reorg, want)
+	}
+	if len(heard) != 1 || !reflect.DeepEqual(heard[0], *reorg) {
+		t.Errorf("listeners got %+v, want the reported reorg", heard)
+	}
+	if !tracker.Known(3, "0xa3") || !tracker.Known(5, "0xb5") || tracker.Known(5, "0xa5") {
+		t.Error("the tracker did not switch to the new branch")
+	}
+	status, _ := tracker.Status()
+	if len(status.Reorgs) != 1 || status.Head.Hash != "0xb6" {
+		t.Errorf("Status() = %+v, want head 0xb6 and one reorg", status)
+	}
+}
+
+func TestApplyAcrossGap(t *testing.T) {
+	tracker := NewTracker(8)
+	tracker.Apply(headers("a", 1, 3))
+	if reorg := tracker.Apply(headers("a", 6, 7)); reorg != nil {
+		t.Fatalf("Apply() after a gap = %+v, want no reorg", reorg)
+	}
+	if _, ok := tracker.Get(5); ok {
+		t.Error("Get() found a block inside the gap")
+	}
+	if !tracker.Known(3, "0xa3") || !tracker.Known(7, "0xa7") {
+		t.Error("blocks around the gap are not tracked")
+	}
+	// a branch replacing the blocks after the gap still finds its ancestor below it
+	reorg := tracker.Apply(headers("b", 6, 6))
+	if reorg == nil || reorg.CommonAncestor != "0x5" || !reflect.DeepEqual(reorg.Removed, []string{"0xa6", "0xa7"}) {
+		t.Errorf("Apply() = %+v, want blocks 6 and 7 removed above ancestor 5", reorg)
+	}
+}
+
+func TestApplyKeepsDepth(t *testing.T) {
+	tracker := NewTracker(4)
+	tracker.Apply(headers("a", 1, 10))
+	status, _ := tracker.Status()
+	if status.Tracked != 4 {
+		t.Errorf("Tracked = %d, want 4", status.Tracked)
+	}
+	if _, ok := tracker.Get(6); ok {
+		t.Error("block 6 is still tracked beyond the depth")
+	}
+	if !tracker.Known(7, "0xa7") {
+		t.Error("block 7 fell out of the ring too early")
+	}
+	// a branch building on a block older than the ring replaces everything tracked
+	reorg := tracker.Apply(headers("b", 5, 6))
+	if reorg == nil || reorg.Depth != 4 || reorg.CommonAncestor != "0x4" {
+		t.Errorf("Apply() = %+v, want the 4 tracked blocks replaced above ancestor 4", reorg)
+	}
+}
+
+func TestNewTrackerClampsDepth(t *testing.T) {
+	for _, test := range []struct{ depth, want int }{{0, DefaultDepth}, {-1, DefaultDepth}, {MaxDepth + 1, MaxDepth}, {16, 16}} {
+		if got := NewTracker(test.depth).Depth; got != test.want {
+			t.Errorf("NewTracker(%d).Depth = %d, want %d", test.depth, got, test.want)
+		}
+	}
+}
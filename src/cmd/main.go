@@ -5,12 +5,19 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/gorilla/mux"
-	"github.com/gorilla/websocket"
 	"github.com/jelias2/infra-test/src/apis"
+	"github.com/jelias2/infra-test/src/cache"
+	"github.com/jelias2/infra-test/src/chain"
 	"github.com/jelias2/infra-test/src/handlers"
+	"github.com/jelias2/infra-test/src/oracle"
+	"github.com/jelias2/infra-test/src/stats"
+	"github.com/jelias2/infra-test/src/upstream"
+	"github.com/jelias2/infra-test/src/webhooks"
 	"go.uber.org/zap"
 )
 
@@ -19,6 +26,8 @@ var (
 	projectSecret            string
 	mainnetHTTPEndpoint      string
 	mainnetWebsocketEndpoint string
+	upstreamHTTPEndpoints    string
+	upstreamStrategy         string
 	err                      error
 )
 
@@ -34,36 +43,78 @@ func main() {
 	projectSecret = os.Getenv("PROJECT_SECRET")
 	mainnetHTTPEndpoint = os.Getenv("MAINNET_HTTP_ENDPOINT")
 	mainnetWebsocketEndpoint = os.Getenv("MAINNET_WEBSOCKET_ENDPOINT")
+	upstreamHTTPEndpoints = os.Getenv("UPSTREAM_HTTP_ENDPOINTS")
+	upstreamStrategy = os.Getenv("UPSTREAM_STRATEGY")
 
 	log.Info("Config vars",
 		zap.String("Project_id", projectID),
 		zap.String("projectSecret", projectSecret),
 		zap.String("mainnetHTTPEndpoint", mainnetHTTPEndpoint),
 		zap.String("mainnetWebsocketEndpoint", mainnetWebsocketEndpoint),
+		zap.String("upstreamStrategy", upstreamStrategy),
 	)
 
-	log.Info("Creating websockets for endpoint connecting to", zap.String("Url", mainnetWebsocketEndpoint))
-	var wsClients = make(map[apis.ClientName]*websocket.Conn)
-	for _, endpoint := range apis.AllWsClients {
-		ws_client, _, err := websocket.DefaultDialer.Dial(mainnetWebsocketEndpoint, nil)
-		if err != nil {
-			log.Fatal("Error creating websocket clients")
-		}
-		wsClients[endpoint] = ws_client
+	wsPoolSize := envInt("WS_POOL_SIZE", upstream.DefaultWsPoolSize)
+	log.Info("Creating websocket pool for endpoint connecting to", zap.String("Url", mainnetWebsocketEndpoint), zap.Int("Size", wsPoolSize))
+	wsPool := upstream.NewWsPool(log, mainnetWebsocketEndpoint, wsPoolSize)
+
+	httpUpstreams, err := upstream.ParseHttpUpstreams(upstreamHTTPEndpoints)
+	if err != nil {
+		log.Fatal("Error parsing UPSTREAM_HTTP_ENDPOINTS", zap.Error(err))
+	}
+	if len(httpUpstreams) == 0 {
+		httpUpstreams = []*upstream.HttpUpstream{{Name: "infura", URL: mainnetHTTPEndpoint, Weight: 1}}
+	}
+	for _, u := range httpUpstreams {
+		log.Info("Configured http upstream", zap.String("Name", u.Name), zap.Int("Weight", u.Weight))
+	}
+
+	breaker := upstream.BreakerConfig{
+		FailureRate:    envFloat("BREAKER_FAILURE_RATE", upstream.DefaultBreakerConfig.FailureRate),
+		SlowCall:       envDuration("BREAKER_SLOW_CALL", upstream.DefaultBreakerConfig.SlowCall),
+		Window:         envInt("BREAKER_WINDOW", upstream.DefaultBreakerConfig.Window),
+		MinRequests:    envInt("BREAKER_MIN_REQUESTS", upstream.DefaultBreakerConfig.MinRequests),
+		OpenTimeout:    envDuration("BREAKER_OPEN_TIMEOUT", upstream.DefaultBreakerConfig.OpenTimeout),
+		HalfOpenProbes: envInt("BREAKER_HALF_OPEN_PROBES", upstream.DefaultBreakerConfig.HalfOpenProbes),
+	}
+
+	gasOraclePercentiles, err := oracle.ParsePercentiles(os.Getenv("GAS_ORACLE_PERCENTILES"))
+	if err != nil {
+		log.Fatal("Error parsing GAS_ORACLE_PERCENTILES", zap.Error(err))
 	}
 
 	handler := &handlers.Handler{
 		Log:                        log,
-		Resty:                      resty.New(),
+		Upstreams:                  upstream.NewHttpPool(log, resty.New(), upstreamStrategy, breaker, httpUpstreams),
 		Mainnet_websocket_endpoint: mainnetWebsocketEndpoint,
-		Mainnet_http_endpoint:      mainnetHTTPEndpoint,
-		WsClients:                  wsClients,
+		WsPool:                     wsPool,
+		Hub:                        upstream.NewHub(log, wsPool, envInt("HUB_CLIENT_BUFFER", upstream.DefaultHubClientBuffer), os.Getenv("HUB_SLOW_CLIENT_POLICY")),
+		BlockCache: cache.NewBlockCache(
+			int64(envInt("BLOCK_CACHE_BYTES", cache.DefaultMaxBytes)),
+			uint64(envInt("BLOCK_CACHE_CONFIRMATIONS", cache.DefaultConfirmations)),
+		),
+		GasOracle:  oracle.New(envInt("GAS_ORACLE_WINDOW", oracle.DefaultWindow), gasOraclePercentiles),
+		ChainStats: stats.NewChain(envInt("STATS_WINDOW", stats.DefaultWindow)),
+		Chain:      chain.NewTracker(envInt("CHAIN_TRACK_DEPTH", chain.DefaultDepth)),
+		Coalescer: upstream.NewCoalescer(
+			envDuration("COALESCE_TTL", upstream.DefaultCoalesceTTL),
+			apis.GetBlockNumber, apis.GetGasPrice,
+		),
+		MaxBatchSize:       envInt("RPC_MAX_BATCH_SIZE", handlers.DefaultMaxBatchSize),
+		MaxPendingRequests: envInt("WS_MAX_PENDING_REQUESTS", handlers.DefaultMaxPendingRequests),
+		MaxSubscriptions:   envInt("WS_MAX_SUBSCRIPTIONS", handlers.DefaultMaxSubscriptions),
+		StreamInterval:     envDuration("STREAM_POLL_INTERVAL", handlers.DefaultStreamInterval),
+		LogsChunkSize:      envInt("LOGS_CHUNK_SIZE", handlers.DefaultLogsChunkSize),
+		LogsParallelism:    envInt("LOGS_PARALLELISM", handlers.DefaultLogsParallelism),
+		FeeHistoryWindow:   envInt("FEE_HISTORY_WINDOW", handlers.DefaultFeeHistoryWindow),
+		Webhooks:           webhooks.NewRegistry(),
+		WebhookDispatcher: webhooks.NewDispatcher(log,
+			resty.New().SetTimeout(envDuration("WEBHOOK_TIMEOUT", webhooks.DefaultTimeout)),
+			envInt("WEBHOOK_MAX_ATTEMPTS", webhooks.DefaultMaxAttempts)),
 	}
 
-	defer handler.WsClients[apis.WsBlockNumber].Close()
-	defer handler.WsClients[apis.WsBlockByNumber].Close()
-	defer handler.WsClients[apis.WsGasPrice].Close()
-	defer handler.WsClients[apis.WsTxByBlockNumberAndIndex].Close()
+	defer handler.WsPool.Close()
+	handler.StartFollower()
 
 	interrupt := make(chan os.Signal, 1) // listen for system interrupt signal to terminate gracefully
 	signal.Notify(interrupt, os.Interrupt)
@@ -72,13 +123,8 @@ func main() {
 			sig := <-interrupt
 			if sig != nil {
 				log.Info("Websocket Recieved Interrupt, closing channel")
-				// Cleanly close the connection
-				for _, endpoint := range apis.AllWsClients {
-					handler.WsClients[endpoint].WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-					if err != nil {
-						log.Fatal("Error Closing Websocket", zap.String("Websocket", string(endpoint)), zap.Error(err))
-					}
-				}
+				// Cleanly close the upstream connections
+				handler.WsPool.Close()
 				// Without this ctrl-c will kills the websocket, and leave the webserver hanging
 				os.Exit(1)
 			}
@@ -87,17 +133,69 @@ func main() {
 
 	r.HandleFunc("/health", handler.Healthcheck).Methods("GET")
 	r.HandleFunc("/", handler.Healthcheck).Methods("GET")
+	r.HandleFunc("/admin/upstreams", handler.AdminUpstreams).Methods("GET")
 	r.HandleFunc("/blocknumber", handler.GetBlockNumber).Methods("GET")
 	r.HandleFunc("/gasprice", handler.GetGasPrice).Methods("GET")
+	r.HandleFunc("/gasprice/oracle", handler.GetGasOracle).Methods("GET")
+	r.HandleFunc("/fees", handler.GetFees).Methods("GET")
+	r.HandleFunc("/stats/chain", handler.GetChainStats).Methods("GET")
+	r.HandleFunc("/chain/head", handler.GetChainHead).Methods("GET")
 	r.HandleFunc("/blockbynumber", handler.GetBlockByNumber).Methods("POST")
 	r.HandleFunc("/txbyblockandindex", handler.GetTransactionByBlockNumberAndIndex).Methods("POST")
+	r.HandleFunc("/blocks/{id}", handler.GetBlock).Methods("GET")
+	r.HandleFunc("/blocks/{id}/transactions/{index}", handler.GetBlockTransaction).Methods("GET")
+	r.HandleFunc("/tx/{hash}", handler.GetTransactionByHash).Methods("GET")
+	r.HandleFunc("/accounts/{address}", handler.GetAccount).Methods("GET")
+	r.HandleFunc("/accounts/{address}/storage/{slot}", handler.GetStorageAt).Methods("GET")
+	r.HandleFunc("/contracts/{address}/call", handler.CallContract).Methods("POST")
+	r.HandleFunc("/tx/{hash}/receipt", handler.GetTransactionReceipt).Methods("GET")
 	r.HandleFunc("/ws/health", handler.Healthcheck).Methods("GET")
 	r.HandleFunc("/ws/blocknumber", handler.WebSocketGetBlockNumber).Methods("GET")
 	r.HandleFunc("/ws/gasprice", handler.WebSocketGetGasPrice).Methods("GET")
 	r.HandleFunc("/ws/blockbynumber", handler.WebSocketGetBlockByNumber).Methods("POST")
 	r.HandleFunc("/ws/txbyblockandindex", handler.WebSocketGetTransactionByBlockNumberAndIndex).Methods("POST")
+	r.HandleFunc("/ws/blocks/{id}", handler.WebSocketGetBlock).Methods("GET")
+	r.HandleFunc("/ws/blocks/{id}/transactions/{index}", handler.WebSocketGetBlockTransaction).Methods("GET")
+	r.HandleFunc("/ws/tx/{hash}", handler.WebSocketGetTransactionByHash).Methods("GET")
+	r.HandleFunc("/ws/tx/{hash}/receipt", handler.WebSocketGetTransactionReceipt).Methods("GET")
+	r.HandleFunc("/rpc", handler.RPC).Methods("POST")
+	r.HandleFunc("/logs", handler.GetLogs).Methods("POST")
+	r.HandleFunc("/stream/blocks", handler.StreamBlocks).Methods("GET")
+	r.HandleFunc("/stream/gasprice", handler.StreamGasPrice).Methods("GET")
+	r.HandleFunc("/webhooks", handler.CreateWebhook).Methods("POST")
+	r.HandleFunc("/webhooks", handler.ListWebhooks).Methods("GET")
+	r.HandleFunc("/webhooks/{id}", handler.GetWebhook).Methods("GET")
+	r.HandleFunc("/webhooks/{id}", handler.DeleteWebhook).Methods("DELETE")
+	r.HandleFunc("/webhooks/{id}/deliveries", handler.GetWebhookDeliveries).Methods("GET")
 	r.HandleFunc("/socket2socket", handler.Socket2socket)
 
 	log.Info("Beginning to server traffic on port")
 	log.Fatal("Error Serving traffic ", zap.Error(http.ListenAndServe(":8000", r)))
 }
+
+// envInt reads an integer environment variable, falling back to def when unset or invalid
+func envInt(name string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// envFloat reads a float environment variable, falling back to def when unset or invalid
+func envFloat(name string, def float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// envDuration reads a duration such as "5s" from the environment, falling back to def when unset or invalid
+func envDuration(name string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return value
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/jelias2/infra-test/src/apis"
+	"github.com/jelias2/infra-test/src/keccak"
+	"go.uber.org/zap"
+)
+
+/*
+* GetAccount returns the balance, nonce and code hash of {address} at the
+* ?block= tag, number or hash (default latest). With ?decode=true the
+* balance is decoded to wei, gwei and ether and the nonce to an integer.
+ */
+func (h *Handler) GetAccount(w http.ResponseWriter, r *http.Request) {
+	address, block, errResp := accountRequest(r)
+	if errResp != nil {
+		h.WriteResponse(w, errResp)
+		return
+	}
+
+	methods := []apis.RPCCall{apis.GetBalance, apis.GetTransactionCount, apis.GetCode}
+	results := make([]string, len(methods))
+	errs := make([]*apis.ErrorResponse, len(methods))
+	var wg sync.WaitGroup
+	for i, method := range methods {
+		wg.Add(1)
+		go func(i int, method apis.RPCCall) {
+			defer wg.Done()
+			results[i], errs[i] = h.hexResult(r.Context(), method, apis.Params(address, block.param()))
+		}(i, method)
+	}
+	wg.Wait()
+	for _, errResp := range errs {
+		if errResp != nil {
+			h.WriteResponse(w, errResp)
+			return
+		}
+	}
+
+	code, err := hex.DecodeString(strings.TrimPrefix(results[2], "0x"))
+	if err != nil {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadGateway, Message: "Invalid upstream result"})
+		return
+	}
+	account := apis.Account{
+		Address:    address,
+		Block:      block.Param,
+		Balance:    results[0],
+		Nonce:      results[1],
+		CodeHash:   keccak.Hex(code),
+		IsContract: len(code) > 0,
+	}
+	h.writeDecoded(w, r, account, quantityNone)
+}
+
+// GetStorageAt returns the value of storage {slot} of {address}, with ?block= and ?decode= as for GetAccount
+func (h *Handler) GetStorageAt(w http.ResponseWriter, r *http.Request) {
+	address, block, errResp := accountRequest(r)
+	if errResp != nil {
+		h.WriteResponse(w, errResp)
+		return
+	}
+	slot, ok := parseSlot(mux.Vars(r)["slot"])
+	if !ok {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "slot must be a decimal or hex number of at most 32 bytes"})
+		return
+	}
+	value, errResp := h.hexResult(r.Context(), apis.GetStorageAt, apis.Params(address, slot, block.param()))
+	if errResp != nil {
+		h.WriteResponse(w, errResp)
+		return
+	}
+	storage := apis.StorageSlot{Address: address, Slot: slot, Block: block.Param, Value: value}
+	h.writeDecoded(w, r, storage, quantityNone)
+}
+
+// accountRequest validates the {address} route variable and the ?block= query parameter
+func accountRequest(r *http.Request) (string, blockID, *apis.ErrorResponse) {
+	address := mux.Vars(r)["address"]
+	if !addressPattern.MatchString(address) {
+		return "", blockID{}, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "address must be a 20 byte hex string starting with 0x"}
+	}
+	block := r.URL.Query().Get("block")
+	if block == "" {
+		block = "latest"
+	}
+	id, ok := parseBlockID(block)
+	if !ok {
+		return "", blockID{}, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: InvalidBlockMessage}
+	}
+	return strings.ToLower(address), id, nil
+}
+
+// param is the block parameter of a state query, block hashes use the EIP-1898 object form
+func (b blockID) param() interface{} {
+	if b.ByHash {
+		return map[string]string{"blockHash": b.Param}
+	}
+	return b.Param
+}
+
+// hexResult sends method upstream and returns its hex string result
+func (h *Handler) hexResult(ctx context.Context, method apis.RPCCall, params []json.RawMessage) (string, *apis.ErrorResponse) {
+	body, _ := json.Marshal(h.CreateRequestBody(method, params))
+	resp, err := h.httpCall(string(method))(ctx, body)
+	if err != nil {
+		h.Log.Error("Error", zap.String("Method", string(method)), zap.Error(err))
+		return "", h.UpstreamError(err)
+	}
+	switch result := h.RPCResult(resp, &apis.HexResultResponse{}).(type) {
+	case *apis.HexResultResponse:
+		return result.Result, nil
+	case *apis.ErrorResponse:
+		return "", result
+	}
+	return "", &apis.ErrorResponse{StatusCode: http.StatusBadGateway, Message: "Invalid upstream result"}
+}
+
+// parseSlot converts a decimal or hex storage slot into a hex quantity
+func parseSlot(slot string) (string, bool) {
+	n, ok := new(big.Int), false
+	if strings.HasPrefix(slot, "0x") {
+		_, ok = n.SetString(slot[2:], 16)
+	} else {
+		_, ok = n.SetString(slot, 10)
+	}
+	if !ok || n.Sign() < 0 || n.BitLen() > 256 {
+		return "", false
+	}
+	return apis.EncodeBig(n), true
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jelias2/infra-test/src/apis"
+	"github.com/jelias2/infra-test/src/upstream"
+	"go.uber.org/zap"
+)
+
+// headRefresh is how old the observed chain head may be before a cache miss refreshes it
+const headRefresh = 12 * time.Second
+
+// httpCall returns a coalesced call over the HTTP upstream pool which logs responses under caller
+func (h *Handler) httpCall(caller string) upstream.CallFunc {
+	post := func(ctx context.Context, body []byte) ([]byte, error) {
+		resp, err := h.Upstreams.Post(ctx, body, nil)
+		h.DebugResponse(caller, resp, err)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body(), nil
+	}
+	return func(ctx context.Context, body []byte) ([]byte, error) {
+		return h.Coalescer.Do(ctx, "http", body, post)
+	}
+}
+
+// wsCall sends body over the upstream websocket pool, coalescing identical requests
+func (h *Handler) wsCall(ctx context.Context, body []byte) ([]byte, error) {
+	return h.Coalescer.Do(ctx, "ws", body, h.WsPool.Call)
+}
+
+/*
+* blockCall serves an eth_getBlockByNumber request body from the block cache
+* when the block is known, otherwise it forwards it through call and caches
+* the response once the block is deep enough behind the chain head.
+ */
+func (h *Handler) blockCall(ctx context.Context, body []byte, call upstream.CallFunc) ([]byte, error) {
+	params := requestParams(body)
+	if len(params) != 2 || h.BlockCache == nil {
+		return call(ctx, body)
+	}
+	var block string
+	var txdetails bool
+	if json.Unmarshal(params[0], &block) != nil || json.Unmarshal(params[1], &txdetails) != nil {
+		return call(ctx, body)
+	}
+	number, ok := apis.ParseBlockNumber(block)
+	if !ok {
+		return call(ctx, body)
+	}
+	if cached, hit := h.BlockCache.Get(number, txdetails); hit {
+		return upstream.ReplaceID(cached, requestID(body))
+	}
+
+	resp, err := call(ctx, body)
+	if err != nil || !hasResult(resp) {
+		return resp, err
+	}
+	if !h.BlockCache.Final(number) {
+		h.refreshHead(ctx, call)
+	}
+	h.BlockCache.Add(number, txdetails, resp)
+	return resp, nil
+}
+
+/*
+* txCall serves an eth_getTransactionByBlockNumberAndIndex request body from a
+* cached block with transaction details when there is one, otherwise it is
+* forwarded through call.
+ */
+func (h *Handler) txCall(ctx context.Context, body []byte, call upstream.CallFunc) ([]byte, error) {
+	params := requestParams(body)
+	if len(params) != 2 || h.BlockCache == nil {
+		return call(ctx, body)
+	}
+	var block, hexIndex string
+	if json.Unmarshal(params[0], &block) != nil || json.Unmarshal(params[1], &hexIndex) != nil {
+		return call(ctx, body)
+	}
+	number, ok := apis.ParseBlockNumber(block)
+	index, err := strconv.ParseUint(strings.TrimPrefix(hexIndex, "0x"), 16, 64)
+	if !ok || err != nil {
+		return call(ctx, body)
+	}
+	cached, hit := h.BlockCache.Get(number, true)
+	if !hit {
+		return call(ctx, body)
+	}
+
+	var cachedBlock struct {
+		Result struct {
+			Transactions []json.RawMessage `json:"transactions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(cached, &cachedBlock); err != nil || index >= uint64(len(cachedBlock.Result.Transactions)) {
+		return call(ctx, body)
+	}
+	return json.Marshal(apis.RPCResponse{
+		JsonRPC: apis.RPCVersion2,
+		ID:      requestID(body),
+		Result:  cachedBlock.Result.Transactions[index],
+	})
+}
+
+// refreshHead fetches eth_blockNumber through call when the observed head is stale
+func (h *Handler) refreshHead(ctx context.Context, call upstream.CallFunc) {
+	if _, at := h.BlockCache.Head(); time.Since(at) < headRefresh {
+		return
+	}
+	body, _ := json.Marshal(h.CreateRequestBody(apis.GetBlockNumber, apis.Params()))
+	resp, err := call(ctx, body)
+	if err != nil {
+		h.Log.Info("Error refreshing chain head for block cache", zap.Error(err))
+		return
+	}
+	h.observeHead(resp)
+}
+
+// observeHead feeds an eth_blockNumber response into the block cache
+func (h *Handler) observeHead(resp []byte) {
+	if h.BlockCache == nil {
+		return
+	}
+	result := &apis.GetBlockNumberResponse{}
+	if err := json.Unmarshal(resp, result); err != nil {
+		return
+	}
+	if number, ok := apis.ParseBlockNumber(result.Result); ok {
+		h.BlockCache.ObserveHead(number)
+	}
+}
+
+// requestParams returns the raw params of a JSON-RPC request body
+func requestParams(body []byte) []json.RawMessage {
+	var req struct {
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil
+	}
+	return req.Params
+}
+
+// requestID returns the raw id of a JSON-RPC request body
+func requestID(body []byte) json.RawMessage {
+	var req struct {
+		ID json.RawMessage `json:"id"`
+	}
+	json.Unmarshal(body, &req)
+	return req.ID
+}
+
+// hasResult reports whether a JSON-RPC response carries a non null result
+func hasResult(resp []byte) bool {
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(resp, &envelope); err != nil {
+		return false
+	}
+	return len(envelope.Result) > 0 && string(envelope.Result) != "null"
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jelias2/infra-test/src/apis"
+	"github.com/jelias2/infra-test/src/upstream"
+	"go.uber.org/zap"
+)
+
+const InvalidBlockMessage = "block must be a block number, a 32 byte block hash or one of latest, earliest, pending, safe, finalized"
+
+// blockID is a validated block reference, either a block hash or a hex block number or tag
+type blockID struct {
+	ByHash bool
+	Param  string
+}
+
+// parseBlockID accepts a block tag, a 32 byte block hash, or a hex or decimal block number
+func parseBlockID(block string) (blockID, bool) {
+	switch {
+	case apis.BlockTags[block]:
+		return blockID{Param: block}, true
+	case hashPattern.MatchString(block):
+		return blockID{ByHash: true, Param: strings.ToLower(block)}, true
+	}
+	number, ok := apis.ParseBlockNumber(block)
+	if !ok {
+		return blockID{}, false
+	}
+	return blockID{Param: apis.EncodeUint64(number)}, true
+}
+
+/*
+* BlockRequestBody validates a block lookup and builds the JSON-RPC request
+* body for it, eth_getBlockByHash for block hashes and eth_getBlockByNumber
+* otherwise. It returns the body, the value of txdetails, or the error to
+* answer with.
+ */
+func (h *Handler) BlockRequestBody(req apis.GetBlockByNumberRequest) ([]byte, bool, *apis.ErrorResponse) {
+	txdetails, err := strconv.ParseBool(req.TxDetails)
+	if req.Block == "" || err != nil {
+		return nil, false, &apis.MalformedRequestError
+	}
+	id, ok := parseBlockID(req.Block)
+	if !ok {
+		return nil, false, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: InvalidBlockMessage}
+	}
+	method := apis.GetBlockByNumber
+	if id.ByHash {
+		method = apis.GetBlockByHash
+	}
+	body, _ := json.Marshal(h.CreateRequestBody(method, apis.Params(id.Param, txdetails)))
+	return body, txdetails, nil
+}
+
+// GetBlock returns the block named by the {id} route variable, with transaction details when ?txdetails=true
+func (h *Handler) GetBlock(w http.ResponseWriter, r *http.Request) {
+	body, txdetails, errResp := h.blockRouteRequest(r)
+	if errResp != nil {
+		h.WriteResponse(w, errResp)
+		return
+	}
+	if txdetails {
+		h.writeDecoded(w, r, h.GetBlockByNumberResponse(r.Context(), body, apis.GetBlockByNumberTxDetailsResponse{}), quantityNone)
+		return
+	}
+	h.writeDecoded(w, r, h.GetBlockByNumberResponse(r.Context(), body, apis.GetBlockByNumberNoTxDetailsResponse{}), quantityNone)
+}
+
+// WebSocketGetBlock is GetBlock over the upstream websocket
+func (h *Handler) WebSocketGetBlock(w http.ResponseWriter, r *http.Request) {
+	body, txdetails, errResp := h.blockRouteRequest(r)
+	if errResp != nil {
+		h.WriteResponse(w, errResp)
+		return
+	}
+	if txdetails {
+		h.writeDecoded(w, r, h.WebSocketGetBlockByNumberHandler(r.Context(), body, apis.GetBlockByNumberTxDetailsResponse{}), quantityNone)
+		return
+	}
+	h.writeDecoded(w, r, h.WebSocketGetBlockByNumberHandler(r.Context(), body, apis.GetBlockByNumberNoTxDetailsResponse{}), quantityNone)
+}
+
+func (h *Handler) blockRouteRequest(r *http.Request) ([]byte, bool, *apis.ErrorResponse) {
+	txdetails := r.URL.Query().Get("txdetails")
+	if txdetails == "" {
+		txdetails = "false"
+	}
+	return h.BlockRequestBody(apis.GetBlockByNumberRequest{Block: mux.Vars(r)["id"], TxDetails: txdetails})
+}
+
+// GetBlockTransaction returns the transaction at {index} of the block named by {id}
+func (h *Handler) GetBlockTransaction(w http.ResponseWriter, r *http.Request) {
+	h.BlockTransactionResponse(w, r, h.httpCall("GetBlockTransaction"))
+}
+
+// WebSocketGetBlockTransaction is GetBlockTransaction over the upstream websocket
+func (h *Handler) WebSocketGetBlockTransaction(w http.ResponseWriter, r *http.Request) {
+	h.BlockTransactionResponse(w, r, h.wsCall)
+}
+
+/*
+* BlockTransactionResponse looks up a transaction by block and index through
+* call. Lookups by block number are served from the block cache when the
+* block is cached with transaction details.
+ */
+func (h *Handler) BlockTransactionResponse(w http.ResponseWriter, r *http.Request, call upstream.CallFunc) {
+	vars := mux.Vars(r)
+	id, ok := parseBlockID(vars["id"])
+	if !ok {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: InvalidBlockMessage})
+		return
+	}
+	index, ok := apis.ParseBlockNumber(vars["index"])
+	if !ok {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "index must be a hex or decimal number"})
+		return
+	}
+
+	method := apis.GetTransactionByBlockNumberAndIndex
+	if id.ByHash {
+		method = apis.GetTransactionByBlockHashAndIndex
+	}
+	body, _ := json.Marshal(h.CreateRequestBody(method, apis.Params(id.Param, apis.EncodeUint64(index))))
+	var resp []byte
+	var err error
+	if id.ByHash {
+		resp, err = call(r.Context(), body)
+	} else {
+		resp, err = h.txCall(r.Context(), body, call)
+	}
+	if err != nil {
+		h.Log.Error("Error", zap.String("Method", string(method)), zap.Error(err))
+		h.WriteResponse(w, h.UpstreamError(err))
+		return
+	}
+	h.writeDecoded(w, r, h.RPCResult(resp, &apis.GetTransactionByBlockNumberAndIndexResponse{}), quantityNone)
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+// GetChainHead reports the head, safe and finalized blocks tracked by the head follower and its recent reorgs
+func (h *Handler) GetChainHead(w http.ResponseWriter, r *http.Request) {
+	status, ok := h.Chain.Status()
+	if !ok {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusServiceUnavailable, Message: "Head follower has not tracked a block yet"})
+		return
+	}
+	h.writeDecoded(w, r, status, quantityNone)
+}
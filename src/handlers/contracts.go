@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+/*
+* CallContract ABI encodes a call of a view function on {address}, runs it
+* with eth_call at the requested block (default latest) and decodes the
+* returned data with the outputs of the function.
+ */
+func (h *Handler) CallContract(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	if !addressPattern.MatchString(address) {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "address must be a 20 byte hex string starting with 0x"})
+		return
+	}
+	var req apis.ContractCallRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	fn, errResp := contractFunction(req)
+	if errResp != nil {
+		h.WriteResponse(w, errResp)
+		return
+	}
+	if req.From != "" && !addressPattern.MatchString(req.From) {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "from must be a 20 byte hex string starting with 0x"})
+		return
+	}
+	if req.Block == "" {
+		req.Block = "latest"
+	}
+	block, ok := parseBlockID(req.Block)
+	if !ok {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: InvalidBlockMessage})
+		return
+	}
+	calldata, err := fn.EncodeCall(req.Args)
+	if err != nil {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	call := apis.CallObject{From: strings.ToLower(req.From), To: strings.ToLower(address), Data: apis.HexData(calldata)}
+	result, errResp := h.hexResult(r.Context(), apis.Call, apis.Params(call, block.param()))
+	if errResp != nil {
+		h.WriteResponse(w, errResp)
+		return
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(result, "0x"))
+	if err != nil {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadGateway, Message: "Invalid upstream result"})
+		return
+	}
+
+	signature, _ := fn.Signature()
+	resp := apis.ContractCallResponse{Address: call.To, Block: block.Param, Function: signature, Data: result, Outputs: []apis.ABIValue{}}
+	if len(fn.Outputs) > 0 {
+		if resp.Outputs, err = fn.DecodeOutputs(data); err != nil {
+			h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Return data does not match the function outputs: " + err.Error()})
+			return
+		}
+	}
+	h.writeDecoded(w, r, resp, quantityNone)
+}
+
+// contractFunction picks the function of a call request from its ABI fragment or signature
+func contractFunction(req apis.ContractCallRequest) (apis.ABIFunction, *apis.ErrorResponse) {
+	var fn apis.ABIFunction
+	var err error
+	switch {
+	case req.ABI != nil:
+		fn = *req.ABI
+		if fn.Type != "" && fn.Type != "function" {
+			return fn, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "abi must be a function fragment"}
+		}
+	case req.Function != "":
+		if fn, err = apis.ParseABISignature(req.Function); err != nil {
+			return fn, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+		}
+	default:
+		return fn, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "function or abi is required"}
+	}
+	if _, err = fn.Signature(); err != nil {
+		return fn, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+	if _, _, err = fn.OutputTypes(); err != nil {
+		return fn, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+	return fn, nil
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jelias2/infra-test/src/apis"
+	"go.uber.org/zap"
+)
+
+// quantityKind is how a hex quantity is presented in a decoded response
+type quantityKind int
+
+const (
+	quantityNone quantityKind = iota
+	quantityInteger
+	quantityBigInteger
+	quantityTimestamp
+	quantityWei
+	quantityGwei
+)
+
+const DecodedProfile = "decoded"
+
+// quantityFields maps the hex quantity fields of blocks, transactions, receipts and logs to their decoding
+var quantityFields = map[string]quantityKind{
+	"number":               quantityInteger,
+	"blockNumber":          quantityInteger,
+	"oldestBlock":          quantityInteger,
+	"commonAncestor":       quantityInteger,
+	"gasLimit":             quantityInteger,
+	"gasUsed":              quantityInteger,
+	"gas":                  quantityInteger,
+	"cumulativeGasUsed":    quantityInteger,
+	"transactionIndex":     quantityInteger,
+	"logIndex":             quantityInteger,
+	"size":                 quantityInteger,
+	"nonce":                quantityInteger,
+	"type":                 quantityInteger,
+	"chainId":              quantityInteger,
+	"status":               quantityInteger,
+	"v":                    quantityInteger,
+	"yParity":              quantityInteger,
+	"blobGasUsed":          quantityInteger,
+	"excessBlobGas":        quantityInteger,
+	"index":                quantityInteger,
+	"validatorIndex":       quantityInteger,
+	"difficulty":           quantityBigInteger,
+	"totalDifficulty":      quantityBigInteger,
+	"timestamp":            quantityTimestamp,
+	"value":                quantityWei,
+	"balance":              quantityWei,
+	"gasPrice":             quantityWei,
+	"maxFeePerGas":         quantityWei,
+	"maxPriorityFeePerGas": quantityWei,
+	"baseFeePerGas":        quantityWei,
+	"oldestBaseFeePerGas":  quantityWei,
+	"effectiveGasPrice":    quantityWei,
+	"maxFeePerBlobGas":     quantityWei,
+	"blobGasPrice":         quantityWei,
+	"minGasPrice":          quantityWei,
+	"medianGasPrice":       quantityWei,
+	"maxGasPrice":          quantityWei,
+	"amount":               quantityGwei,
+}
+
+// decodeRequested reports whether the client asked for decoded quantities with ?decode=true or an Accept profile
+func decodeRequested(r *http.Request) bool {
+	if decode, _ := strconv.ParseBool(r.URL.Query().Get("decode")); decode {
+		return true
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if _, params, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && params["profile"] == DecodedProfile {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+* writeDecoded writes v like WriteResponse. When the client asked for it,
+* hex quantities are decoded first: counters become integers, difficulties
+* decimal strings, timestamps RFC3339 and wei amounts an object with the
+* amount in wei, gwei and ether. A scalar "result" is decoded as result.
+ */
+func (h *Handler) writeDecoded(w http.ResponseWriter, r *http.Request, v interface{}, result quantityKind) {
+	if _, isError := v.(*apis.ErrorResponse); isError || !decodeRequested(r) {
+		h.WriteResponse(w, v)
+		return
+	}
+	decoded, err := decodeQuantities(v, result)
+	if err != nil {
+		h.Log.Error("Error decoding response quantities", zap.Error(err))
+		h.WriteResponse(w, v)
+		return
+	}
+	h.WriteResponse(w, decoded)
+}
+
+func decodeQuantities(v interface{}, result quantityKind) (interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	if envelope, ok := generic.(map[string]interface{}); ok {
+		if s, ok := envelope["result"].(string); ok {
+			envelope["result"] = decodeQuantity(s, result)
+			return envelope, nil
+		}
+	}
+	return decodeFields(generic), nil
+}
+
+// decodeFields walks a JSON value decoding the known quantity fields of every object
+func decodeFields(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		// the nonce of a block is an 8 byte proof of work value, not a counter
+		_, isBlock := value["miner"]
+		// the value of a storage slot is a 32 byte word, not an amount of wei
+		_, isStorage := value["slot"]
+		// the outputs of a contract call are decoded from the ABI already, hex values there are addresses and bytes
+		_, isCall := value["function"]
+		for key, field := range value {
+			if key == "outputs" && isCall {
+				continue
+			}
+			s, isString := field.(string)
+			if !isString {
+				value[key] = decodeFields(field)
+				continue
+			}
+			if key == "nonce" && isBlock {
+				continue
+			}
+			if key == "value" && isStorage {
+				value[key] = decodeQuantity(s, quantityBigInteger)
+				continue
+			}
+			value[key] = decodeQuantity(s, quantityFields[key])
+		}
+		return value
+	case []interface{}:
+		for i := range value {
+			value[i] = decodeFields(value[i])
+		}
+		return value
+	}
+	return v
+}
+
+// decodeQuantity converts a single hex quantity, anything that is not one is returned unchanged
+func decodeQuantity(s string, kind quantityKind) interface{} {
+	if kind == quantityNone {
+		return s
+	}
+	n, ok := apis.ParseQuantity(s)
+	if !ok {
+		return s
+	}
+	switch kind {
+	case quantityInteger:
+		if n.IsUint64() {
+			return n.Uint64()
+		}
+		return n.String()
+	case quantityTimestamp:
+		if !n.IsInt64() {
+			return n.String()
+		}
+		return time.Unix(n.Int64(), 0).UTC().Format(time.RFC3339)
+	case quantityGwei:
+		n.Mul(n, big.NewInt(1e9))
+		return apis.WeiAmount{Wei: n.String(), Gwei: formatUnits(n, 9), Ether: formatUnits(n, 18)}
+	case quantityWei:
+		return apis.WeiAmount{Wei: n.String(), Gwei: formatUnits(n, 9), Ether: formatUnits(n, 18)}
+	}
+	return n.String()
+}
+
+// formatUnits renders n divided by 10^decimals as an exact decimal string
+func formatUnits(n *big.Int, decimals int) string {
+	base := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	whole, frac := new(big.Int).QuoRem(new(big.Int).Abs(n), base, new(big.Int))
+	s := whole.String()
+	if frac.Sign() != 0 {
+		digits := frac.String()
+		s += "." + strings.TrimRight(strings.Repeat("0", decimals-len(digits))+digits, "0")
+	}
+	if n.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
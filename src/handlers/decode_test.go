@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+func TestDecodeQuantities(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		in     string
+		result quantityKind
+		want   string
+	}{
+		{
+			name: "block nonce is kept",
+			in:   `{"miner":"0x01","nonce":"0x0000000000000042","number":"0x10","timestamp":"0x5f5e100"}`,
+			want: `{"miner":"0x01","nonce":"0x0000000000000042","number":16,"timestamp":"1973-03-03T09:46:40Z"}`,
+		},
+		{
+			name: "transaction nonce is a counter",
+			in:   `{"nonce":"0x7","value":"0xde0b6b3a7640000","type":"0x2"}`,
+			want: `{"nonce":7,"value":{"wei":"1000000000000000000","gwei":"1000000000","ether":"1"},"type":2}`,
+		},
+		{
+			name: "storage value is a word",
+			in:   `{"slot":"0x0","value":"0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"}`,
+			want: `{"slot":"0x0","value":"115792089237316195423570985008687907853269984665640564039457584007913129639935"}`,
+		},
+		{
+			name: "contract call outputs are kept",
+			in:   `{"function":"owner()","outputs":[{"type":"address","value":"0x00000000000000000000000000000000000000aa"}]}`,
+			want: `{"function":"owner()","outputs":[{"type":"address","value":"0x00000000000000000000000000000000000000aa"}]}`,
+		},
+		{
+			name: "withdrawal amount is gwei",
+			in:   `{"withdrawals":[{"index":"0x1","amount":"0x3b9aca00"}]}`,
+			want: `{"withdrawals":[{"amount":{"wei":"1000000000000000000","gwei":"1000000000","ether":"1"},"index":1}]}`,
+		},
+		{
+			name: "difficulty beyond uint64",
+			in:   `{"totalDifficulty":"0xc70d815d562d3cfa955","number":"not hex"}`,
+			want: `{"number":"not hex","totalDifficulty":"58750003716598352816469"}`,
+		},
+		{
+			name:   "scalar result",
+			in:     `{"jsonrpc":"2.0","id":1,"result":"0x3b9aca00"}`,
+			result: quantityWei,
+			want:   `{"id":1,"jsonrpc":"2.0","result":{"wei":"1000000000","gwei":"1","ether":"0.000000001"}}`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			decoded, err := decodeQuantities(json.RawMessage(test.in), test.result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := canonicalJSON(t, decoded), canonicalJSON(t, json.RawMessage(test.want)); got != want {
+				t.Errorf("decodeQuantities() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// canonicalJSON re-encodes v with sorted object keys
+func canonicalJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ = json.Marshal(generic)
+	return string(raw)
+}
+
+func TestFormatUnits(t *testing.T) {
+	for _, test := range []struct {
+		wei      string
+		decimals int
+		want     string
+	}{
+		{"0", 18, "0"},
+		{"1", 18, "0.000000000000000001"},
+		{"1500000000000000000", 18, "1.5"},
+		{"123456789", 9, "0.123456789"},
+		{"-2500000000", 9, "-2.5"},
+		{"1000000000000000000000000", 18, "1000000"},
+	} {
+		n, _ := new(big.Int).SetString(test.wei, 10)
+		if got := formatUnits(n, test.decimals); got != test.want {
+			t.Errorf("formatUnits(%s, %d) = %s, want %s", test.wei, test.decimals, got, test.want)
+		}
+	}
+}
+
+func TestDecodeRequested(t *testing.T) {
+	for _, test := range []struct {
+		url, accept string
+		want        bool
+	}{
+		{"/block?decode=true", "", true},
+		{"/block?decode=1", "", true},
+		{"/block?decode=false", "", false},
+		{"/block", "application/json", false},
+		{"/block", `text/html, application/json; profile="decoded"`, true},
+	} {
+		r := httptest.NewRequest(http.MethodGet, test.url, nil)
+		r.Header.Set("Accept", test.accept)
+		if got := decodeRequested(r); got != test.want {
+			t.Errorf("decodeRequested(%s, %q) = %v, want %v", test.url, test.accept, got, test.want)
+		}
+	}
+}
+
+func TestCallContractDecoded(t *testing.T) {
+	h := newTestHandler(t, func(method apis.RPCCall, params []json.RawMessage) (interface{}, *apis.RPCError) {
+		return "0x00000000000000000000000000000000000000000000000000000000000000aa", nil
+	})
+	body := `{"function":"owner()(address)"}`
+	req := httptest.NewRequest(http.MethodPost, "/contracts/0x00000000000000000000000000000000000000bb/call?decode=true", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"address": "0x00000000000000000000000000000000000000bb"})
+	rec := httptest.NewRecorder()
+	h.CallContract(rec, req)
+
+	var resp apis.ContractCallResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("CallContract() = %s: %v", rec.Body, err)
+	}
+	if len(resp.Outputs) != 1 || resp.Outputs[0].Value != "0x00000000000000000000000000000000000000aa" {
+		t.Errorf("CallContract() = %s, want the owner address undecoded", rec.Body)
+	}
+}
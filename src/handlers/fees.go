@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/jelias2/infra-test/src/apis"
+	"go.uber.org/zap"
+)
+
+const DefaultFeeHistoryWindow = 20
+
+// maxFeeHistoryWindow is the most blocks eth_feeHistory returns in one call
+const maxFeeHistoryWindow = 1024
+
+/*
+* feeTiers are the reward percentiles requested from eth_feeHistory and how
+* many blocks of maximum base fee growth (12.5% each) the max fee of each
+* tier can absorb before the transaction is priced out.
+ */
+var feeTiers = []struct {
+	Percentile float64
+	Headroom   int
+}{
+	{Percentile: 10, Headroom: 1},
+	{Percentile: 50, Headroom: 3},
+	{Percentile: 90, Headroom: 6},
+}
+
+// feeCache holds the suggestions of the last head they were computed for
+type feeCache struct {
+	mu          sync.Mutex
+	head        uint64
+	suggestions *apis.FeeSuggestions
+}
+
+/*
+* GetFees suggests slow, standard and fast EIP-1559 fees from the priority
+* fees paid over the last FeeHistoryWindow blocks and the projected base
+* fee. Suggestions are computed by the background head follower each time
+* the head advances, GetFees only serves the last computed ones.
+ */
+func (h *Handler) GetFees(w http.ResponseWriter, r *http.Request) {
+	h.fees.mu.Lock()
+	suggestions := h.fees.suggestions
+	h.fees.mu.Unlock()
+	if suggestions == nil {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusServiceUnavailable, Message: "Head follower has no fee suggestions yet"})
+		return
+	}
+	h.writeDecoded(w, r, suggestions, quantityNone)
+}
+
+// updateFees computes the suggestions for the tracked head, unless they are already computed for it
+func (h *Handler) updateFees(ctx context.Context) {
+	head, ok := h.Chain.Head()
+	if !ok {
+		return
+	}
+	h.fees.mu.Lock()
+	current := h.fees.suggestions != nil && h.fees.head == head.Number
+	h.fees.mu.Unlock()
+	if current {
+		return
+	}
+	suggestions, errResp := h.feeSuggestions(ctx, head.Number)
+	if errResp != nil {
+		h.Log.Info("Error computing fee suggestions", zap.Uint64("Block", head.Number), zap.String("Message", errResp.Message))
+		return
+	}
+	h.fees.mu.Lock()
+	h.fees.head, h.fees.suggestions = head.Number, suggestions
+	h.fees.mu.Unlock()
+}
+
+func (h *Handler) feeSuggestions(ctx context.Context, head uint64) (*apis.FeeSuggestions, *apis.ErrorResponse) {
+	window := h.FeeHistoryWindow
+	if window <= 0 {
+		window = DefaultFeeHistoryWindow
+	}
+	if window > maxFeeHistoryWindow {
+		window = maxFeeHistoryWindow
+	}
+	percentiles := make([]float64, len(feeTiers))
+	for i, tier := range feeTiers {
+		percentiles[i] = tier.Percentile
+	}
+
+	var gasPrice string
+	var gasPriceErr *apis.ErrorResponse
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		gasPrice, gasPriceErr = h.hexResult(ctx, apis.GetGasPrice, apis.Params())
+	}()
+	body, _ := json.Marshal(h.CreateRequestBody(apis.FeeHistory, apis.Params(apis.EncodeUint64(uint64(window)), apis.EncodeUint64(head), percentiles)))
+	resp, err := h.httpCall("GetFees")(ctx, body)
+	wg.Wait()
+	if err != nil {
+		h.Log.Error("Error", zap.String("Method", string(apis.FeeHistory)), zap.Error(err))
+		return nil, h.UpstreamError(err)
+	}
+	if gasPriceErr != nil {
+		return nil, gasPriceErr
+	}
+	var history apis.FeeHistoryResult
+	switch result := h.RPCResult(resp, &apis.GetFeeHistoryResponse{}).(type) {
+	case *apis.ErrorResponse:
+		return nil, result
+	case *apis.GetFeeHistoryResponse:
+		history = result.Result
+	}
+	if len(history.BaseFeePerGas) == 0 {
+		return nil, &apis.ErrorResponse{StatusCode: http.StatusBadGateway, Message: "Upstream returned no fee history"}
+	}
+
+	baseFee, _ := apis.ParseQuantity(history.BaseFeePerGas[len(history.BaseFeePerGas)-1])
+	if baseFee == nil {
+		return nil, &apis.ErrorResponse{StatusCode: http.StatusBadGateway, Message: "Invalid upstream result"}
+	}
+	suggestions := &apis.FeeSuggestions{
+		BlockNumber:   apis.EncodeUint64(head),
+		Window:        len(history.Reward),
+		BaseFeePerGas: apis.EncodeBig(baseFee),
+		GasPrice:      gasPrice,
+	}
+	if n := len(history.BaseFeePerBlobGas); n > 0 {
+		suggestions.BaseFeePerBlobGas = history.BaseFeePerBlobGas[n-1]
+	}
+
+	tiers := []*apis.FeeEstimate{&suggestions.Slow, &suggestions.Standard, &suggestions.Fast}
+	for i, tier := range feeTiers {
+		priority := medianReward(history, i)
+		if priority == nil {
+			// every block of the window was empty, fall back to the node's own suggestion
+			tip, errResp := h.hexResult(ctx, apis.MaxPriorityFeePerGas, apis.Params())
+			if errResp != nil {
+				return nil, errResp
+			}
+			if priority, _ = apis.ParseQuantity(tip); priority == nil {
+				return nil, &apis.ErrorResponse{StatusCode: http.StatusBadGateway, Message: "Invalid upstream result"}
+			}
+		}
+		maxFee := projectBaseFee(baseFee, tier.Headroom)
+		maxFee.Add(maxFee, priority)
+		*tiers[i] = apis.FeeEstimate{MaxPriorityFeePerGas: apis.EncodeBig(priority), MaxFeePerGas: apis.EncodeBig(maxFee)}
+	}
+	return suggestions, nil
+}
+
+// medianReward is the median of the reward at percentile index tier over the non empty blocks of the window
+func medianReward(history apis.FeeHistoryResult, tier int) *big.Int {
+	var rewards []*big.Int
+	for i, reward := range history.Reward {
+		if i < len(history.GasUsedRatio) && history.GasUsedRatio[i] == 0 || tier >= len(reward) {
+			continue
+		}
+		if n, ok := apis.ParseQuantity(reward[tier]); ok {
+			rewards = append(rewards, n)
+		}
+	}
+	if len(rewards) == 0 {
+		return nil
+	}
+	sort.Slice(rewards, func(i, j int) bool { return rewards[i].Cmp(rewards[j]) < 0 })
+	return rewards[len(rewards)/2]
+}
+
+// projectBaseFee is the base fee after blocks blocks of maximum growth
+func projectBaseFee(baseFee *big.Int, blocks int) *big.Int {
+	projected := new(big.Int).Set(baseFee)
+	for i := 0; i < blocks; i++ {
+		projected.Mul(projected, big.NewInt(9))
+		projected.Div(projected, big.NewInt(8))
+	}
+	return projected
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jelias2/infra-test/src/apis"
+	"github.com/jelias2/infra-test/src/chain"
+)
+
+func TestMedianReward(t *testing.T) {
+	history := apis.FeeHistoryResult{
+		GasUsedRatio: []float64{0.5, 0, 0.9, 0.3, 0.7},
+		Reward: [][]string{
+			{"0x1", "0x10"},
+			{"0x1000", "0x1000"}, // empty block, skipped
+			{"0x3", "0x30"},
+			{"0x2", "invalid"},
+			{"0x5"},
+		},
+	}
+	for _, test := range []struct {
+		tier int
+		want string
+	}{
+		// 1, 2, 3, 5
+		{0, "3"},
+		// 0x10, 0x30, the invalid and missing rewards are skipped
+		{1, "48"},
+		{2, ""},
+	} {
+		got := medianReward(history, test.tier)
+		if test.want == "" {
+			if got != nil {
+				t.Errorf("medianReward(%d) = %s, want nil", test.tier, got)
+			}
+			continue
+		}
+		if got == nil || got.String() != test.want {
+			t.Errorf("medianReward(%d) = %v, want %s", test.tier, got, test.want)
+		}
+	}
+
+	empty := apis.FeeHistoryResult{GasUsedRatio: []float64{0, 0}, Reward: [][]string{{"0x1"}, {"0x2"}}}
+	if got := medianReward(empty, 0); got != nil {
+		t.Errorf("medianReward() of empty blocks = %s, want nil", got)
+	}
+}
+
+func TestProjectBaseFee(t *testing.T) {
+	baseFee := big.NewInt(8000000000)
+	for _, test := range []struct {
+		blocks int
+		want   string
+	}{
+		{0, "8000000000"},
+		{1, "9000000000"},
+		{3, "11390625000"},
+		// integer division rounds down every block
+		{6, "16218292235"},
+	} {
+		if got := projectBaseFee(baseFee, test.blocks); got.String() != test.want {
+			t.Errorf("projectBaseFee(%s, %d) = %s, want %s", baseFee, test.blocks, got, test.want)
+		}
+	}
+	if baseFee.String() != "8000000000" {
+		t.Errorf("projectBaseFee() modified the base fee to %s", baseFee)
+	}
+}
+
+func TestFeesFollowHead(t *testing.T) {
+	var feeHistoryCalls int64
+	h := newTestHandler(t, func(method apis.RPCCall, params []json.RawMessage) (interface{}, *apis.RPCError) {
+		switch method {
+		case apis.GetGasPrice:
+			return "0x3b9aca00", nil
+		case apis.FeeHistory:
+			atomic.AddInt64(&feeHistoryCalls, 1)
+			return apis.FeeHistoryResult{
+				BaseFeePerGas: []string{"0x1dcd65000", "0x1dcd65000"},
+				GasUsedRatio:  []float64{0.5},
+				Reward:        [][]string{{"0x1", "0x2", "0x3"}},
+			}, nil
+		}
+		return nil, &apis.RPCError{Code: -32601, Message: "method not found"}
+	})
+	h.Chain = chain.NewTracker(8)
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.GetFees(rec, httptest.NewRequest(http.MethodGet, "/fees", nil))
+		return rec
+	}
+	if rec := get(); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("GetFees() before the first head = %d, want 503", rec.Code)
+	}
+
+	h.Chain.Apply([]chain.Header{{Number: 10, Hash: "0xa", ParentHash: "0x9"}})
+	h.updateFees(context.Background())
+	h.updateFees(context.Background())
+	rec := get()
+	var suggestions apis.FeeSuggestions
+	if err := json.Unmarshal(rec.Body.Bytes(), &suggestions); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GetFees() = %d %s", rec.Code, rec.Body)
+	}
+	want := apis.FeeSuggestions{
+		BlockNumber:   "0xa",
+		Window:        1,
+		BaseFeePerGas: "0x1dcd65000",
+		GasPrice:      "0x3b9aca00",
+		Slow:          apis.FeeEstimate{MaxPriorityFeePerGas: "0x1", MaxFeePerGas: "0x218711a01"},
+		Standard:      apis.FeeEstimate{MaxPriorityFeePerGas: "0x2", MaxFeePerGas: "0x2a6ef24ea"},
+		Fast:          apis.FeeEstimate{MaxPriorityFeePerGas: "0x3", MaxFeePerGas: "0x3c6af800e"},
+	}
+	if suggestions != want {
+		t.Errorf("GetFees() = %+v, want %+v", suggestions, want)
+	}
+	if n := atomic.LoadInt64(&feeHistoryCalls); n != 1 {
+		t.Errorf("eth_feeHistory called %d times for one head, want 1", n)
+	}
+
+	h.Chain.Apply([]chain.Header{{Number: 11, Hash: "0xb", ParentHash: "0xa"}})
+	h.updateFees(context.Background())
+	if n := atomic.LoadInt64(&feeHistoryCalls); n != 2 {
+		t.Errorf("eth_feeHistory called %d times for two heads, want 2", n)
+	}
+}
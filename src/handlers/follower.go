@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/jelias2/infra-test/src/apis"
+	"github.com/jelias2/infra-test/src/chain"
+	"github.com/jelias2/infra-test/src/upstream"
+	"go.uber.org/zap"
+)
+
+// followerParallelism is how many blocks the head follower fetches at once while catching up
+const followerParallelism = 8
+
+// followerHeadTimeout is how long the newHeads subscription may stay silent before the follower polls, and how often it is retried
+const followerHeadTimeout = 30 * time.Second
+
+var newHeadsParams = json.RawMessage(`["newHeads"]`)
+
+/*
+* StartFollower starts the background head follower, it is called once after
+* the Handler is built. The routes it feeds answer 503 until it has tracked
+* its first block.
+ */
+func (h *Handler) StartFollower() {
+	h.followOnce.Do(func() {
+		h.Chain.OnReorg(h.onReorg)
+		go h.watchChain()
+	})
+}
+
+/*
+* watchChain catches up with the polled head, then follows the heads
+* announced by the shared newHeads subscription. While the subscription is
+* down, or silent for longer than followerHeadTimeout, eth_blockNumber is
+* polled every StreamInterval instead.
+ */
+func (h *Handler) watchChain() {
+	interval := h.StreamInterval
+	if interval <= 0 {
+		interval = DefaultStreamInterval
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), interval*5)
+	h.updateChain(ctx, nil)
+	cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var sub *upstream.HubSubscription
+	var heads <-chan json.RawMessage
+	var lastHead, lastAttempt time.Time
+	for {
+		if sub == nil && h.Hub != nil && time.Since(lastAttempt) >= followerHeadTimeout {
+			lastAttempt = time.Now()
+			if sub = h.subscribeHeads(interval); sub != nil {
+				heads, lastHead = sub.C, time.Now()
+				h.Chain.SetSource(chain.SourceSubscription)
+			}
+		}
+		select {
+		case result, ok := <-heads:
+			if !ok {
+				h.Log.Info("newHeads subscription closed, polling for new heads", zap.Error(sub.Err()))
+				sub, heads = nil, nil
+				h.Chain.SetSource(chain.SourcePolling)
+				continue
+			}
+			var tip struct {
+				Number     string `json:"number"`
+				Hash       string `json:"hash"`
+				ParentHash string `json:"parentHash"`
+			}
+			if json.Unmarshal(result, &tip) != nil {
+				continue
+			}
+			number, ok := apis.ParseBlockNumber(tip.Number)
+			if !ok {
+				continue
+			}
+			lastHead = time.Now()
+			h.Chain.SetSource(chain.SourceSubscription)
+			ctx, cancel := context.WithTimeout(context.Background(), interval*5)
+			h.updateChain(ctx, &chain.Header{Number: number, Hash: tip.Hash, ParentHash: tip.ParentHash})
+			cancel()
+		case <-ticker.C:
+			if sub != nil && time.Since(lastHead) < followerHeadTimeout {
+				continue
+			}
+			h.Chain.SetSource(chain.SourcePolling)
+			ctx, cancel := context.WithTimeout(context.Background(), interval*5)
+			h.updateChain(ctx, nil)
+			cancel()
+		}
+	}
+}
+
+// subscribeHeads joins the shared newHeads subscription, nil when the upstream websockets are unavailable
+func (h *Handler) subscribeHeads(timeout time.Duration) *upstream.HubSubscription {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	sub, err := h.Hub.Subscribe(ctx, newHeadsParams)
+	if err != nil {
+		h.Log.Info("Error subscribing to newHeads, polling for new heads", zap.Error(err))
+		return nil
+	}
+	return sub
+}
+
+// chainHead polls the chain head the same way GetBlockNumber does
+func (h *Handler) chainHead(ctx context.Context) (uint64, bool) {
+	body, _ := json.Marshal(h.CreateRequestBody(apis.GetBlockNumber, apis.Params()))
+	resp, err := h.httpCall("ChainHead")(ctx, body)
+	if err != nil {
+		h.Log.Info("Error fetching chain head", zap.Error(err))
+		return 0, false
+	}
+	h.observeHead(resp)
+	result := &apis.GetBlockNumberResponse{}
+	if err := json.Unmarshal(resp, result); err != nil || result.Error != nil {
+		return 0, false
+	}
+	return apis.ParseBlockNumber(result.Result)
+}
+
+/*
+* updateChain fetches the blocks after the tracked head up to the new head,
+* tip when it was announced by the subscription, otherwise the polled block
+* number. At most the largest window kept is fetched, so the first update
+* fills the windows. Blocks are fetched followerParallelism at a time and
+* followed in order, a block that can not be fetched is retried on the next
+* update. The fee suggestions are computed last, once per head.
+ */
+func (h *Handler) updateChain(ctx context.Context, tip *chain.Header) {
+	h.followMu.Lock()
+	defer h.followMu.Unlock()
+	defer h.updateFees(ctx)
+	var head uint64
+	if tip != nil {
+		if h.Chain.Known(tip.Number, tip.Hash) {
+			return
+		}
+		head = tip.Number
+	} else {
+		var ok bool
+		if head, ok = h.chainHead(ctx); !ok {
+			return
+		}
+	}
+
+	window := uint64(h.GasOracle.Window)
+	if uint64(h.ChainStats.Window) > window {
+		window = uint64(h.ChainStats.Window)
+	}
+	var from uint64
+	if last, ok := h.Chain.Head(); ok {
+		if tip == nil && head <= last.Number {
+			return
+		}
+		from = last.Number + 1
+		// an announced head at or below the tracked one replaces it
+		if head < from {
+			from = head
+		}
+	}
+	if head-from >= window {
+		from = head - window + 1
+	}
+
+	defer h.updateCheckpoints(ctx)
+	for from <= head {
+		to := head
+		if head-from >= followerParallelism {
+			to = from + followerParallelism - 1
+		}
+		blocks := make([]*apis.BlockTxDetails, to-from+1)
+		var wg sync.WaitGroup
+		for n := from; n <= to; n++ {
+			wg.Add(1)
+			go func(n uint64) {
+				defer wg.Done()
+				blocks[n-from] = h.followerBlock(ctx, apis.GetBlockByNumber, apis.EncodeUint64(n))
+			}(n)
+		}
+		wg.Wait()
+
+		for i, block := range blocks {
+			if block == nil {
+				h.Log.Info("Error fetching block for head follower", zap.Uint64("Block", from+uint64(i)))
+				return
+			}
+			if !h.followBlock(ctx, block) {
+				return
+			}
+		}
+		from = to + 1
+	}
+}
+
+/*
+* followBlock applies block to the chain tracker and feeds it to the gas
+* price oracle, the chain statistics, the block stream and the webhooks. When its parent hash differs from
+* the tracked block before it, its ancestors are fetched by hash until one
+* is tracked, and the tracker reports the replaced blocks as a reorg.
+ */
+func (h *Handler) followBlock(ctx context.Context, block *apis.BlockTxDetails) bool {
+	branch := []*apis.BlockTxDetails{block}
+	for len(branch) < h.Chain.Depth {
+		first, ok := blockHeader(branch[0])
+		if !ok {
+			h.Log.Info("Head follower got a block without a number", zap.String("Hash", branch[0].Hash))
+			return false
+		}
+		if first.Number == 0 {
+			break
+		}
+		parent, tracked := h.Chain.Get(first.Number - 1)
+		if !tracked || parent.Hash == first.ParentHash {
+			break
+		}
+		ancestor := h.followerBlock(ctx, apis.GetBlockByHash, first.ParentHash)
+		if ancestor == nil {
+			h.Log.Info("Error fetching reorganized block for head follower", zap.String("Hash", first.ParentHash))
+			return false
+		}
+		branch = append([]*apis.BlockTxDetails{ancestor}, branch...)
+	}
+
+	headers := make([]chain.Header, 0, len(branch))
+	for _, b := range branch {
+		header, _ := blockHeader(b)
+		headers = append(headers, header)
+	}
+	h.Chain.Apply(headers)
+	for _, b := range branch {
+		if err := h.GasOracle.Add(b); err != nil {
+			h.Log.Info("Error adding block to gas price oracle", zap.String("Block", b.Number), zap.Error(err))
+		}
+		if err := h.ChainStats.Add(b); err != nil {
+			h.Log.Info("Error adding block to chain statistics", zap.String("Block", b.Number), zap.Error(err))
+		}
+		h.publishBlock(b)
+		h.notifyWebhooks(b)
+	}
+	return true
+}
+
+// followerBlock fetches a block with its transactions by number or hash, nil when it is unavailable
+func (h *Handler) followerBlock(ctx context.Context, method apis.RPCCall, block string) *apis.BlockTxDetails {
+	body, _ := json.Marshal(h.CreateRequestBody(method, apis.Params(block, true)))
+	resp, ok := h.GetBlockByNumberResponse(ctx, body, apis.GetBlockByNumberTxDetailsResponse{}).(*apis.GetBlockByNumberTxDetailsResponse)
+	if !ok {
+		return nil
+	}
+	return &resp.Result
+}
+
+// updateCheckpoints refreshes the safe and finalized blocks, they are left out when the upstream does not know them
+func (h *Handler) updateCheckpoints(ctx context.Context) {
+	var checkpoints [2]*chain.Header
+	for i, tag := range []string{"safe", "finalized"} {
+		body, _ := json.Marshal(h.CreateRequestBody(apis.GetBlockByNumber, apis.Params(tag, false)))
+		resp, ok := h.GetBlockByNumberResponse(ctx, body, apis.GetBlockByNumberNoTxDetailsResponse{}).(*apis.GetBlockByNumberNoTxDetailsResponse)
+		if !ok {
+			continue
+		}
+		if header, ok := blockHeader(&apis.BlockTxDetails{BlockHeader: resp.Result.BlockHeader}); ok {
+			checkpoints[i] = &header
+		}
+	}
+	h.Chain.SetCheckpoints(checkpoints[0], checkpoints[1])
+}
+
+/*
+* onReorg lets the block cache, the block stream and the webhooks forget the
+* replaced blocks: cached blocks after the common ancestor are evicted,
+* stream clients get a "reorg" event before the blocks of the new chain and
+* webhooks get an "orphaned" delivery for every replaced block delivered to
+* them.
+ */
+func (h *Handler) onReorg(reorg apis.ReorgEvent) {
+	h.Log.Info("Chain reorganization detected",
+		zap.Int("Depth", reorg.Depth),
+		zap.String("CommonAncestor", reorg.CommonAncestor),
+		zap.String("OldHead", reorg.OldHead),
+		zap.String("NewHead", reorg.NewHead))
+	ancestor, _ := apis.ParseBlockNumber(reorg.CommonAncestor)
+	if h.BlockCache != nil {
+		h.BlockCache.Invalidate(ancestor + 1)
+	}
+	blockFeed, _ := h.streamFeeds()
+	data, _ := json.Marshal(reorg)
+	blockFeed.rewind(ancestor, sseEvent{Event: "reorg", Data: data})
+	h.orphanWebhooks(reorg)
+}
+
+func blockHeader(block *apis.BlockTxDetails) (chain.Header, bool) {
+	number, ok := apis.ParseBlockNumber(block.Number)
+	return chain.Header{Number: number, Hash: block.Hash, ParentHash: block.ParentHash}, ok
+}
@@ -1,27 +1,59 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strconv"
+	"regexp"
+	"sync"
 
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/jelias2/infra-test/src/apis"
+	"github.com/jelias2/infra-test/src/cache"
+	"github.com/jelias2/infra-test/src/chain"
+	"github.com/jelias2/infra-test/src/oracle"
+	"github.com/jelias2/infra-test/src/stats"
+	"github.com/jelias2/infra-test/src/upstream"
+	"github.com/jelias2/infra-test/src/webhooks"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 )
 
+var hashPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{64}$`)
+
 type Handler struct {
 	Log                        *zap.Logger
-	Resty                      *resty.Client
-	WsClients                  map[apis.ClientName]*websocket.Conn
-	Mainnet_http_endpoint      string
+	Upstreams                  *upstream.HttpPool
+	WsPool                     *upstream.WsPool
+	Hub                        *upstream.Hub
+	BlockCache                 *cache.BlockCache
+	GasOracle                  *oracle.Oracle
+	ChainStats                 *stats.Chain
+	Chain                      *chain.Tracker
+	Coalescer                  *upstream.Coalescer
+	MaxBatchSize               int
+	MaxPendingRequests         int
+	MaxSubscriptions           int
+	StreamInterval             time.Duration
+	LogsChunkSize              int
+	LogsParallelism            int
+	FeeHistoryWindow           int
+	Webhooks                   *webhooks.Registry
+	WebhookDispatcher          *webhooks.Dispatcher
 	Mainnet_websocket_endpoint string
+
+	streamsOnce sync.Once
+	blockFeed   *sseFeed
+	gasFeed     *sseFeed
+
+	followOnce sync.Once
+	followMu   sync.Mutex
+
+	fees feeCache
 }
 
 // Healthcheck will display test response to make sure the server is running
@@ -29,42 +61,53 @@ func (h *Handler) Healthcheck(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	h.Log.Info("Entered Healthcheck")
 	json.NewEncoder(w).Encode(apis.Healthcheck{
-		Status:   http.StatusAccepted,
-		Message:  "Healthcheck response",
-		Datetime: time.Now().String(),
+		Status:     http.StatusAccepted,
+		Message:    "Healthcheck response",
+		Datetime:   time.Now().String(),
+		Websockets: h.WsPool.Status(),
+		Upstreams:  h.Upstreams.Status(),
+	})
+}
+
+// AdminUpstreams reports the health and circuit breaker state of every upstream
+func (h *Handler) AdminUpstreams(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apis.UpstreamsStatus{
+		Http:          h.Upstreams.Status(),
+		Websockets:    h.WsPool.Status(),
+		Subscriptions: h.Hub.Status(),
 	})
 }
 
 // Get ethblock number
 func (h *Handler) GetBlockNumber(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	getBlockBody := h.CreateRequestBody(apis.GetBlockNumber, []string{})
-	result := &apis.GetBlockNumberResponse{}
-	resp, err := h.Resty.R().SetBody(getBlockBody).
-		SetResult(result).
-		Post(h.Mainnet_http_endpoint)
-	h.DebugResponse("GetBlockNumber", resp, err)
-	json.NewEncoder(w).Encode(result)
+	getBlockBody, _ := json.Marshal(h.CreateRequestBody(apis.GetBlockNumber, apis.Params()))
+	resp, err := h.httpCall("GetBlockNumber")(r.Context(), getBlockBody)
+	if err != nil {
+		h.WriteResponse(w, h.UpstreamError(err))
+		return
+	}
+	h.observeHead(resp)
+	h.writeDecoded(w, r, h.RPCResult(resp, &apis.GetBlockNumberResponse{}), quantityInteger)
 }
 
 // Get GetGasPrice number
 func (h *Handler) GetGasPrice(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	h.Log.Info("Entered GetGasPrice")
-	getGasBody := h.CreateRequestBody(apis.GetGasPrice, []string{})
-	result := &apis.GetGasPriceResponse{}
-	resp, err := h.Resty.R().SetBody(getGasBody).
-		SetResult(result).
-		Post(h.Mainnet_http_endpoint)
-	h.DebugResponse("GetBlockNumber", resp, err)
-	json.NewEncoder(w).Encode(result)
+	getGasBody, _ := json.Marshal(h.CreateRequestBody(apis.GetGasPrice, apis.Params()))
+	resp, err := h.httpCall("GetGasPrice")(r.Context(), getGasBody)
+	if err != nil {
+		h.WriteResponse(w, h.UpstreamError(err))
+		return
+	}
+	h.writeDecoded(w, r, h.RPCResult(resp, &apis.GetGasPriceResponse{}), quantityWei)
 }
 
 // GetBlockByNumber
 func (h *Handler) GetTransactionByBlockNumberAndIndex(w http.ResponseWriter, r *http.Request) {
 
-	var err error
-	var resp *resty.Response
 	w.Header().Set("Content-Type", "application/json")
 	h.Log.Info("Entered GetTransactionByBlockNumberAndIndex")
 	reqBody, _ := ioutil.ReadAll(r.Body)
@@ -74,20 +117,18 @@ func (h *Handler) GetTransactionByBlockNumberAndIndex(w http.ResponseWriter, r *
 	}
 
 	if getTxReq.Block == "" || getTxReq.Index == "" {
-		json.NewEncoder(w).Encode(apis.MalformedRequestError)
+		h.WriteResponse(w, apis.MalformedRequestError)
 		return
 	}
 
-	getBlockNumberAndTxBody := h.CreateRequestBody(apis.GetTransactionByBlockNumberAndIndex, []string{getTxReq.Block, getTxReq.Index})
-	result := &apis.GetTransactionByBlockNumberAndIndexResponse{}
-	resp, err = h.Resty.R().SetBody(getBlockNumberAndTxBody).
-		SetResult(result).
-		Post(h.Mainnet_http_endpoint)
+	getBlockNumberAndTxBody, _ := json.Marshal(h.CreateRequestBody(apis.GetTransactionByBlockNumberAndIndex, apis.Params(getTxReq.Block, getTxReq.Index)))
+	resp, err := h.txCall(r.Context(), getBlockNumberAndTxBody, h.httpCall("GetTransactionByBlockNumberAndIndex"))
 	if err != nil {
 		h.Log.Error("Error", zap.Error(err))
+		h.WriteResponse(w, h.UpstreamError(err))
+		return
 	}
-	h.DebugResponse("GetTransactionByBlockNumberAndIndex", resp, err)
-	json.NewEncoder(w).Encode(result)
+	h.writeDecoded(w, r, h.RPCResult(resp, &apis.GetTransactionByBlockNumberAndIndexResponse{}), quantityNone)
 
 }
 
@@ -100,13 +141,13 @@ func (h *Handler) GetBlockByNumber(w http.ResponseWriter, r *http.Request) {
 	if !validRequest {
 		wsError := &apis.ErrorResponse{}
 		json.Unmarshal(formmattedRequest, wsError)
-		json.NewEncoder(w).Encode(wsError)
+		h.WriteResponse(w, wsError)
 		return
 	}
 	if txdetails {
-		json.NewEncoder(w).Encode(h.GetBlockByNumberResponse(formmattedRequest, apis.GetBlockByNumberTxDetailsResponse{}))
+		h.writeDecoded(w, r, h.GetBlockByNumberResponse(r.Context(), formmattedRequest, apis.GetBlockByNumberTxDetailsResponse{}), quantityNone)
 	} else {
-		json.NewEncoder(w).Encode(h.GetBlockByNumberResponse(formmattedRequest, apis.GetBlockByNumberNoTxDetailsResponse{}))
+		h.writeDecoded(w, r, h.GetBlockByNumberResponse(r.Context(), formmattedRequest, apis.GetBlockByNumberNoTxDetailsResponse{}), quantityNone)
 	}
 }
 
@@ -129,43 +170,71 @@ func (h *Handler) ParseGetBlockByNumberRequest(r *http.Request) ([]byte, bool, b
 		return errorBody, false, false
 	}
 
-	txdetails, err := strconv.ParseBool(getBlockByNumberRequest.TxDetails)
-	if getBlockByNumberRequest.Block == "" || err != nil {
-		errorBody, _ := json.Marshal(apis.MalformedRequestError)
+	body, txdetails, errResp := h.BlockRequestBody(getBlockByNumberRequest)
+	if errResp != nil {
+		errorBody, _ := json.Marshal(errResp)
 		return errorBody, false, false
 	}
-
-	body := []byte(fmt.Sprintf(apis.BooleanRequestBodyTemplate, apis.GetBlockByNumber, getBlockByNumberRequest.Block, getBlockByNumberRequest.TxDetails))
 	h.Log.Info("GetBlockByNumber body", zap.String("Body", string(body)))
 	return body, true, txdetails
 }
 
-func (h *Handler) GetBlockByNumberResponse(body []byte, unmashallStruct interface{}) interface{} {
-	var err error
-	var resp *resty.Response
-
-	resp, err = h.Resty.R().SetBody(body).
-		Post(h.Mainnet_http_endpoint)
+func (h *Handler) GetBlockByNumberResponse(ctx context.Context, body []byte, unmashallStruct interface{}) interface{} {
+	resp, err := h.blockCall(ctx, body, h.httpCall("GetBlockByNumber"))
 	if err != nil {
 		h.Log.Error("Error", zap.Error(err))
+		return h.UpstreamError(err)
 	}
-	h.DebugResponse("GetBlockByNumber", resp, err)
 	switch unmashallStruct.(type) {
 	case apis.GetBlockByNumberTxDetailsResponse:
-		result := &apis.GetBlockByNumberTxDetailsResponse{}
-		json.Unmarshal(resp.Body(), result)
-		return result
+		return h.RPCResult(resp, &apis.GetBlockByNumberTxDetailsResponse{})
 	case apis.GetBlockByNumberNoTxDetailsResponse:
-		result := &apis.GetBlockByNumberNoTxDetailsResponse{}
-		json.Unmarshal(resp.Body(), result)
-		return result
+		return h.RPCResult(resp, &apis.GetBlockByNumberNoTxDetailsResponse{})
 	default:
-		return &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()}
+		return &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Error Unmarshalling GetBlockResponse"}
 	}
 }
 
+// GetTransactionByHash looks up a transaction by its hash
+func (h *Handler) GetTransactionByHash(w http.ResponseWriter, r *http.Request) {
+	h.TransactionByHashResponse(w, r, apis.GetTransactionByHash, h.httpCall("GetTransactionByHash"))
+}
+
+// GetTransactionReceipt looks up the receipt of a mined transaction by its hash
+func (h *Handler) GetTransactionReceipt(w http.ResponseWriter, r *http.Request) {
+	h.TransactionByHashResponse(w, r, apis.GetTransactionReceipt, h.httpCall("GetTransactionReceipt"))
+}
+
+/*
+* TransactionByHashResponse validates the {hash} route variable and sends
+* method with it through call, answering with the transaction or its receipt.
+ */
+func (h *Handler) TransactionByHashResponse(w http.ResponseWriter, r *http.Request, method apis.RPCCall, call upstream.CallFunc) {
+	hash := mux.Vars(r)["hash"]
+	if !hashPattern.MatchString(hash) {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "hash must be a 32 byte hex string starting with 0x"})
+		return
+	}
+	body, _ := json.Marshal(h.CreateRequestBody(method, apis.Params(hash)))
+	resp, err := call(r.Context(), body)
+	if err != nil {
+		h.Log.Error("Error", zap.String("Method", string(method)), zap.Error(err))
+		h.WriteResponse(w, h.UpstreamError(err))
+		return
+	}
+	if method == apis.GetTransactionReceipt {
+		h.writeDecoded(w, r, h.RPCResult(resp, &apis.GetTransactionReceiptResponse{}), quantityNone)
+		return
+	}
+	h.writeDecoded(w, r, h.RPCResult(resp, &apis.GetTransactionByHashResponse{}), quantityNone)
+}
+
 func (h *Handler) DebugResponse(caller string, resp *resty.Response, err error) {
 	h.Log.Info("Handling response from", zap.String("caller", caller))
+	if resp == nil {
+		h.Log.Info("Response Info:", zap.Error(err))
+		return
+	}
 	h.Log.Info("Response Info:",
 		zap.Error(err),
 		zap.Int("Status Code:", resp.StatusCode()),
@@ -174,7 +243,10 @@ func (h *Handler) DebugResponse(caller string, resp *resty.Response, err error)
 		zap.Time("Received At:", resp.ReceivedAt()))
 }
 
-func (h *Handler) CreateRequestBody(method apis.RPCCall, params []string) *apis.InfuraRequestBody {
+func (h *Handler) CreateRequestBody(method apis.RPCCall, params []json.RawMessage) *apis.InfuraRequestBody {
+	if params == nil {
+		params = []json.RawMessage{}
+	}
 	return &apis.InfuraRequestBody{
 		JsonRPC: apis.RPCVersion2,
 		Method:  method,
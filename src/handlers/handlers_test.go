@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/jelias2/infra-test/src/apis"
+	"github.com/jelias2/infra-test/src/upstream"
+	"go.uber.org/zap"
+)
+
+// rpcFunc answers a single JSON-RPC call of the fake upstream with a result or an error
+type rpcFunc func(method apis.RPCCall, params []json.RawMessage) (interface{}, *apis.RPCError)
+
+// newTestHandler returns a Handler whose only http upstream is an httptest server answering with rpc
+func newTestHandler(t *testing.T, rpc rpcFunc) *Handler {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req apis.InfuraRequestBody
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, rpcErr := rpc(req.Method, req.Params)
+		resp := map[string]interface{}{"jsonrpc": apis.RPCVersion2, "id": req.ID}
+		if rpcErr != nil {
+			resp["error"] = rpcErr
+		} else {
+			resp["result"] = result
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	log := zap.NewNop()
+	return &Handler{
+		Log:       log,
+		Upstreams: upstream.NewHttpPool(log, resty.New(), upstream.RoundRobin, upstream.DefaultBreakerConfig, []*upstream.HttpUpstream{{Name: "test", URL: server.URL, Weight: 1}}),
+	}
+}
@@ -1,11 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
 
-	"github.com/gorilla/websocket"
 	"github.com/jelias2/infra-test/src/apis"
 	"go.uber.org/zap"
 )
@@ -13,31 +13,40 @@ import (
 // WebSocketGetGasPrice
 func (h *Handler) WebSocketGetBlockNumber(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	getBlockBody, _ := json.Marshal(h.CreateRequestBody(apis.GetBlockNumber, []string{}))
-	message, ErrorResponse := h.WebSocketWriteAndRead(apis.WsBlockNumber, getBlockBody)
+	getBlockBody, _ := json.Marshal(h.CreateRequestBody(apis.GetBlockNumber, apis.Params()))
+	message, ErrorResponse := h.WebSocketWriteAndRead(r.Context(), getBlockBody)
 	if ErrorResponse.Message != "" && ErrorResponse.StatusCode != 0 {
-		json.NewEncoder(w).Encode(ErrorResponse)
+		h.WriteResponse(w, ErrorResponse)
 		return
 	}
-	wsGetBlockNumberResponse := &apis.GetBlockNumberResponse{}
-	json.Unmarshal(message, wsGetBlockNumberResponse)
+	h.observeHead(message)
+	wsGetBlockNumberResponse := h.RPCResult(message, &apis.GetBlockNumberResponse{})
 	h.Log.Info("WebSocketGetBlockNumber Response", zap.Any("Response", wsGetBlockNumberResponse))
-	json.NewEncoder(w).Encode(wsGetBlockNumberResponse)
+	h.writeDecoded(w, r, wsGetBlockNumberResponse, quantityInteger)
+}
+
+// WebSocketGetTransactionByHash looks up a transaction by its hash over the upstream websocket
+func (h *Handler) WebSocketGetTransactionByHash(w http.ResponseWriter, r *http.Request) {
+	h.TransactionByHashResponse(w, r, apis.GetTransactionByHash, h.wsCall)
+}
+
+// WebSocketGetTransactionReceipt looks up a transaction receipt over the upstream websocket
+func (h *Handler) WebSocketGetTransactionReceipt(w http.ResponseWriter, r *http.Request) {
+	h.TransactionByHashResponse(w, r, apis.GetTransactionReceipt, h.wsCall)
 }
 
 // WebSocketGetGasPrice
 func (h *Handler) WebSocketGetGasPrice(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	getBlockBody, _ := json.Marshal(h.CreateRequestBody(apis.GetGasPrice, []string{}))
-	message, ErrorResponse := h.WebSocketWriteAndRead(apis.WsGasPrice, getBlockBody)
+	getBlockBody, _ := json.Marshal(h.CreateRequestBody(apis.GetGasPrice, apis.Params()))
+	message, ErrorResponse := h.WebSocketWriteAndRead(r.Context(), getBlockBody)
 	if ErrorResponse.Message != "" && ErrorResponse.StatusCode != 0 {
-		json.NewEncoder(w).Encode(ErrorResponse)
+		h.WriteResponse(w, ErrorResponse)
 		return
 	}
-	wsGetGasResponse := &apis.GetGasPriceResponse{}
-	json.Unmarshal(message, wsGetGasResponse)
+	wsGetGasResponse := h.RPCResult(message, &apis.GetGasPriceResponse{})
 	h.Log.Info("WebSocketGetGasPrice Response", zap.Any("Response", wsGetGasResponse))
-	json.NewEncoder(w).Encode(wsGetGasResponse)
+	h.writeDecoded(w, r, wsGetGasResponse, quantityWei)
 }
 
 // WebSocketGetGasPrice
@@ -50,33 +59,31 @@ func (h *Handler) WebSocketGetBlockByNumber(w http.ResponseWriter, r *http.Reque
 	if !validRequest {
 		wsError := &apis.ErrorResponse{}
 		json.Unmarshal(formmattedRequest, wsError)
-		json.NewEncoder(w).Encode(wsError)
+		h.WriteResponse(w, wsError)
 		return
 	}
 
 	if txdetails {
-		json.NewEncoder(w).Encode(h.WebSocketGetBlockByNumberHandler(formmattedRequest, apis.GetBlockByNumberTxDetailsResponse{}))
+		h.writeDecoded(w, r, h.WebSocketGetBlockByNumberHandler(r.Context(), formmattedRequest, apis.GetBlockByNumberTxDetailsResponse{}), quantityNone)
+		return
 	}
-	json.NewEncoder(w).Encode(h.WebSocketGetBlockByNumberHandler(formmattedRequest, apis.GetBlockByNumberNoTxDetailsResponse{}))
+	h.writeDecoded(w, r, h.WebSocketGetBlockByNumberHandler(r.Context(), formmattedRequest, apis.GetBlockByNumberNoTxDetailsResponse{}), quantityNone)
 }
 
-func (h *Handler) WebSocketGetBlockByNumberHandler(body []byte, umarshallStruct interface{}) interface{} {
-	var message []byte
-	var errorResponse apis.ErrorResponse
-	message, errorResponse = h.WebSocketWriteAndRead(apis.WsBlockByNumber, body)
-	if errorResponse.Message != "" && errorResponse.StatusCode != 0 {
-		return errorResponse
+func (h *Handler) WebSocketGetBlockByNumberHandler(ctx context.Context, body []byte, umarshallStruct interface{}) interface{} {
+	message, err := h.blockCall(ctx, body, h.wsCall)
+	if err != nil {
+		h.Log.Info("Error calling upstream websocket pool", zap.Error(err))
+		return h.UpstreamError(err)
 	}
 
 	switch umarshallStruct.(type) {
 	case apis.GetBlockByNumberTxDetailsResponse:
-		wsResult := &apis.GetBlockByNumberTxDetailsResponse{}
-		json.Unmarshal(message, wsResult)
+		wsResult := h.RPCResult(message, &apis.GetBlockByNumberTxDetailsResponse{})
 		h.Log.Info("WebSocketGetBlockByNumber Response", zap.Any("Response", wsResult))
 		return wsResult
 	case apis.GetBlockByNumberNoTxDetailsResponse:
-		wsResult := &apis.GetBlockByNumberNoTxDetailsResponse{}
-		json.Unmarshal(message, wsResult)
+		wsResult := h.RPCResult(message, &apis.GetBlockByNumberNoTxDetailsResponse{})
 		h.Log.Info("WebSocketGetBlockByNumber Response", zap.Any("Response", wsResult))
 		return wsResult
 	default:
@@ -96,41 +103,30 @@ func (h *Handler) WebSocketGetTransactionByBlockNumberAndIndex(w http.ResponseWr
 	}
 
 	if getTxReq.Block == "" || getTxReq.Index == "" {
-		json.NewEncoder(w).Encode(apis.MalformedRequestError)
+		h.WriteResponse(w, apis.MalformedRequestError)
 		return
 	}
 
-	getBlockTxIndex, _ := json.Marshal(h.CreateRequestBody(apis.GetTransactionByBlockNumberAndIndex, []string{getTxReq.Block, getTxReq.Index}))
-	message, errorResponse := h.WebSocketWriteAndRead(apis.WsTxByBlockNumberAndIndex, getBlockTxIndex)
-	if errorResponse.Message != "" && errorResponse.StatusCode != 0 {
-		json.NewEncoder(w).Encode(errorResponse)
+	getBlockTxIndex, _ := json.Marshal(h.CreateRequestBody(apis.GetTransactionByBlockNumberAndIndex, apis.Params(getTxReq.Block, getTxReq.Index)))
+	message, err := h.txCall(r.Context(), getBlockTxIndex, h.wsCall)
+	if err != nil {
+		h.Log.Info("Error calling upstream websocket pool", zap.Error(err))
+		h.WriteResponse(w, h.UpstreamError(err))
+		return
 	}
 
-	wsGetTxByBlockAndIndexResp := &apis.GetTransactionByBlockNumberAndIndexResponse{}
-	json.Unmarshal(message, wsGetTxByBlockAndIndexResp)
+	wsGetTxByBlockAndIndexResp := h.RPCResult(message, &apis.GetTransactionByBlockNumberAndIndexResponse{})
 	h.Log.Info("WebSocketGetTransactionByBlockNumberAndIndex Response", zap.Any("Response", wsGetTxByBlockAndIndexResp))
-	json.NewEncoder(w).Encode(wsGetTxByBlockAndIndexResp)
+	h.writeDecoded(w, r, wsGetTxByBlockAndIndexResp, quantityNone)
 
 }
 
-func (h *Handler) WebSocketWriteAndRead(caller apis.ClientName, body []byte) ([]byte, apis.ErrorResponse) {
-	errorMessage := ""
-	statusCode := 0
-	err := h.WsClients[caller].WriteMessage(websocket.TextMessage, body)
+// WebSocketWriteAndRead sends body over the upstream websocket pool and returns the matching response
+func (h *Handler) WebSocketWriteAndRead(ctx context.Context, body []byte) ([]byte, apis.ErrorResponse) {
+	message, err := h.wsCall(ctx, body)
 	if err != nil {
-		h.Log.Info("Error writing WebSocketGetGasPrice websocket message", zap.Error(err))
-		errorMessage = err.Error()
-		statusCode = http.StatusBadRequest
-	}
-	_, message, err := h.WsClients[caller].ReadMessage()
-	if err != nil {
-		h.Log.Info("Error reading WebSocketGetGasPrice websocket message", zap.Error(err))
-		errorMessage = err.Error()
-		statusCode = http.StatusBadRequest
-	}
-	errorResponse := apis.ErrorResponse{
-		StatusCode: statusCode,
-		Message:    errorMessage,
+		h.Log.Info("Error calling upstream websocket pool", zap.Error(err))
+		return nil, *h.UpstreamError(err)
 	}
-	return message, errorResponse
+	return message, apis.ErrorResponse{}
 }
@@ -0,0 +1,345 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jelias2/infra-test/src/apis"
+	"go.uber.org/zap"
+)
+
+const DefaultLogsChunkSize = 2000
+const DefaultLogsParallelism = 4
+const DefaultLogsLimit = 1000
+const MaxLogsLimit = 10000
+
+// maxLogsPageChunks bounds how many chunks are scanned for a single page, sparse ranges continue on the next page
+const maxLogsPageChunks = 32
+
+var addressPattern = regexp.MustCompile("^0x[0-9a-fA-F]{40}$")
+
+var errInvalidCursor = errors.New("cursor is invalid")
+
+// logsCursor is the position of the first log of the next page
+type logsCursor struct {
+	Block uint64
+	Skip  int
+	To    uint64
+}
+
+func (c logsCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d:%d", c.Block, c.Skip, c.To)))
+}
+
+func parseLogsCursor(s string) (logsCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return logsCursor{}, errInvalidCursor
+	}
+	var c logsCursor
+	if n, err := fmt.Sscanf(string(raw), "%d:%d:%d", &c.Block, &c.Skip, &c.To); err != nil || n != 3 || c.Skip < 0 || c.Block > c.To {
+		return logsCursor{}, errInvalidCursor
+	}
+	return c, nil
+}
+
+/*
+* GetLogs returns the event logs matching an address and topic filter over a
+* block range. The range is split into chunks of LogsChunkSize blocks which
+* are fetched LogsParallelism at a time, a chunk the upstream refuses as too
+* large is split again. Results are merged in block and log index order and
+* paged with an opaque cursor which pins the resolved block range.
+ */
+func (h *Handler) GetLogs(w http.ResponseWriter, r *http.Request) {
+	var req apis.LogsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	filter, errResp := logFilter(req)
+	if errResp != nil {
+		h.WriteResponse(w, errResp)
+		return
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultLogsLimit
+	}
+	if limit > MaxLogsLimit {
+		limit = MaxLogsLimit
+	}
+
+	var cursor logsCursor
+	if req.Cursor != "" {
+		var err error
+		if cursor, err = parseLogsCursor(req.Cursor); err != nil {
+			h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()})
+			return
+		}
+	} else {
+		if cursor.Block, errResp = h.resolveBlock(r.Context(), req.FromBlock); errResp != nil {
+			h.WriteResponse(w, errResp)
+			return
+		}
+		if cursor.To, errResp = h.resolveBlock(r.Context(), req.ToBlock); errResp != nil {
+			h.WriteResponse(w, errResp)
+			return
+		}
+		if cursor.Block > cursor.To {
+			h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "fromBlock must not be after toBlock"})
+			return
+		}
+	}
+
+	page, err := h.logsPage(r.Context(), filter, cursor, limit)
+	if err != nil {
+		h.Log.Error("Error", zap.String("Method", string(apis.GetLogs)), zap.Error(err))
+		var rpcErr *apis.RPCError
+		if errors.As(err, &rpcErr) {
+			h.WriteResponse(w, &apis.ErrorResponse{StatusCode: rpcErrorStatus(rpcErr), Message: rpcErr.Message, Error: rpcErr})
+			return
+		}
+		h.WriteResponse(w, h.UpstreamError(err))
+		return
+	}
+	h.writeDecoded(w, r, page, quantityNone)
+}
+
+// logsPage collects up to limit logs starting at cursor
+func (h *Handler) logsPage(ctx context.Context, filter apis.LogFilter, cursor logsCursor, limit int) (*apis.LogsResponse, error) {
+	chunkSize := uint64(DefaultLogsChunkSize)
+	if h.LogsChunkSize > 0 {
+		chunkSize = uint64(h.LogsChunkSize)
+	}
+	parallelism := h.LogsParallelism
+	if parallelism <= 0 {
+		parallelism = DefaultLogsParallelism
+	}
+
+	page := &apis.LogsResponse{FromBlock: apis.EncodeUint64(cursor.Block), ToBlock: apis.EncodeUint64(cursor.To), Logs: []apis.Log{}}
+	skip := cursor.Skip
+	from := cursor.Block
+	// done is set once the chunk ending at cursor.To is scheduled, from+1 would wrap at the largest block number
+	done := false
+	for scanned := 0; !done; {
+		if scanned >= maxLogsPageChunks {
+			page.NextCursor = logsCursor{Block: from, To: cursor.To}.String()
+			return page, nil
+		}
+		var ranges [][2]uint64
+		for len(ranges) < parallelism && scanned < maxLogsPageChunks && !done {
+			to := cursor.To
+			if cursor.To-from >= chunkSize {
+				to = from + chunkSize - 1
+			}
+			ranges = append(ranges, [2]uint64{from, to})
+			scanned++
+			if to == cursor.To {
+				done = true
+			} else {
+				from = to + 1
+			}
+		}
+
+		results := make([][]apis.Log, len(ranges))
+		errs := make([]error, len(ranges))
+		var wg sync.WaitGroup
+		for i, rng := range ranges {
+			wg.Add(1)
+			go func(i int, from, to uint64) {
+				defer wg.Done()
+				results[i], errs[i] = h.fetchLogs(ctx, filter, from, to)
+			}(i, rng[0], rng[1])
+		}
+		wg.Wait()
+
+		for i, logs := range results {
+			if errs[i] != nil {
+				return nil, errs[i]
+			}
+			sortLogs(logs)
+			for _, log := range logs {
+				block, _ := apis.ParseBlockNumber(log.BlockNumber)
+				if block == cursor.Block && skip > 0 {
+					skip--
+					continue
+				}
+				if len(page.Logs) == limit {
+					page.NextCursor = nextLogsCursor(page.Logs, block, cursor).String()
+					return page, nil
+				}
+				page.Logs = append(page.Logs, log)
+			}
+		}
+	}
+	return page, nil
+}
+
+// nextLogsCursor points at the first log of block after the logs already returned
+func nextLogsCursor(returned []apis.Log, block uint64, cursor logsCursor) logsCursor {
+	next := logsCursor{Block: block, To: cursor.To}
+	if block == cursor.Block {
+		next.Skip = cursor.Skip
+	}
+	for i := len(returned) - 1; i >= 0; i-- {
+		if n, _ := apis.ParseBlockNumber(returned[i].BlockNumber); n != block {
+			break
+		}
+		next.Skip++
+	}
+	return next
+}
+
+// fetchLogs runs eth_getLogs over [from, to], halving the range while the upstream reports too many results
+func (h *Handler) fetchLogs(ctx context.Context, filter apis.LogFilter, from, to uint64) ([]apis.Log, error) {
+	filter.FromBlock, filter.ToBlock = apis.EncodeUint64(from), apis.EncodeUint64(to)
+	body, _ := json.Marshal(h.CreateRequestBody(apis.GetLogs, apis.Params(filter)))
+	resp, err := h.httpCall("GetLogs")(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	result := &apis.GetLogsResponse{}
+	if err := json.Unmarshal(resp, result); err != nil {
+		return nil, err
+	}
+	if result.Error == nil {
+		return result.Result, nil
+	}
+	if to == from || !logRangeTooLarge(result.Error) {
+		return nil, result.Error
+	}
+	mid := from + (to-from)/2
+	h.Log.Info("Splitting log range", zap.Uint64("From", from), zap.Uint64("To", to), zap.String("Reason", result.Error.Message))
+	first, err := h.fetchLogs(ctx, filter, from, mid)
+	if err != nil {
+		return nil, err
+	}
+	second, err := h.fetchLogs(ctx, filter, mid+1, to)
+	if err != nil {
+		return nil, err
+	}
+	return append(first, second...), nil
+}
+
+// logRangeTooLarge reports whether an eth_getLogs error asks for a smaller block range
+func logRangeTooLarge(e *apis.RPCError) bool {
+	if e.Code == rpcLimitExceeded {
+		return true
+	}
+	message := strings.ToLower(e.Message)
+	for _, hint := range []string{"more than", "too many", "exceed", "range"} {
+		if strings.Contains(message, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// logFilter validates the addresses and topics of a logs request
+func logFilter(req apis.LogsRequest) (apis.LogFilter, *apis.ErrorResponse) {
+	var filter apis.LogFilter
+	addresses := req.Addresses
+	if req.Address != "" {
+		addresses = append([]string{req.Address}, addresses...)
+	}
+	for _, address := range addresses {
+		if !addressPattern.MatchString(address) {
+			return filter, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "addresses must be 20 byte hex strings starting with 0x"}
+		}
+		filter.Address = append(filter.Address, strings.ToLower(address))
+	}
+	if len(req.Topics) > 4 {
+		return filter, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "at most 4 topics are allowed"}
+	}
+	for _, topic := range req.Topics {
+		if !validTopic(topic) {
+			return filter, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "topics must be null, a 32 byte hex string or an array of them"}
+		}
+	}
+	filter.Topics = req.Topics
+	return filter, nil
+}
+
+func validTopic(topic json.RawMessage) bool {
+	if string(topic) == "null" {
+		return true
+	}
+	var single string
+	if json.Unmarshal(topic, &single) == nil {
+		return hashPattern.MatchString(single)
+	}
+	var alternatives []*string
+	if json.Unmarshal(topic, &alternatives) != nil {
+		return false
+	}
+	for _, t := range alternatives {
+		if t != nil && !hashPattern.MatchString(*t) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveBlock turns a block number or tag into a block number, an empty block is the latest block
+func (h *Handler) resolveBlock(ctx context.Context, block string) (uint64, *apis.ErrorResponse) {
+	switch block {
+	case "earliest":
+		return 0, nil
+	case "", "latest", "pending":
+		body, _ := json.Marshal(h.CreateRequestBody(apis.GetBlockNumber, apis.Params()))
+		return h.resolveResult(ctx, body, func(resp []byte) string {
+			result := &apis.GetBlockNumberResponse{}
+			json.Unmarshal(resp, result)
+			return result.Result
+		})
+	case "safe", "finalized":
+		body, _ := json.Marshal(h.CreateRequestBody(apis.GetBlockByNumber, apis.Params(block, false)))
+		return h.resolveResult(ctx, body, func(resp []byte) string {
+			result := &apis.GetBlockByNumberNoTxDetailsResponse{}
+			json.Unmarshal(resp, result)
+			return result.Result.Number
+		})
+	}
+	number, ok := apis.ParseBlockNumber(block)
+	if !ok {
+		return 0, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "fromBlock and toBlock must be block numbers or one of latest, earliest, pending, safe, finalized"}
+	}
+	return number, nil
+}
+
+func (h *Handler) resolveResult(ctx context.Context, body []byte, number func(resp []byte) string) (uint64, *apis.ErrorResponse) {
+	resp, err := h.httpCall("GetLogs")(ctx, body)
+	if err != nil {
+		return 0, h.UpstreamError(err)
+	}
+	if errResp, ok := h.RPCResult(resp, &json.RawMessage{}).(*apis.ErrorResponse); ok {
+		return 0, errResp
+	}
+	n, ok := apis.ParseBlockNumber(number(resp))
+	if !ok {
+		return 0, &apis.ErrorResponse{StatusCode: http.StatusBadGateway, Message: "Invalid upstream result"}
+	}
+	return n, nil
+}
+
+// sortLogs orders logs by block number and log index
+func sortLogs(logs []apis.Log) {
+	sort.SliceStable(logs, func(i, j int) bool {
+		bi, _ := apis.ParseBlockNumber(logs[i].BlockNumber)
+		bj, _ := apis.ParseBlockNumber(logs[j].BlockNumber)
+		if bi != bj {
+			return bi < bj
+		}
+		li, _ := apis.ParseBlockNumber(logs[i].LogIndex)
+		lj, _ := apis.ParseBlockNumber(logs[j].LogIndex)
+		return li < lj
+	})
+}
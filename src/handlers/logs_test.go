@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+// logsUpstream serves eth_getLogs with two logs in every block, refusing ranges wider than maxRange blocks
+type logsUpstream struct {
+	maxRange uint64
+	mu       sync.Mutex
+	ranges   [][2]uint64
+}
+
+func (u *logsUpstream) rpc(method apis.RPCCall, params []json.RawMessage) (interface{}, *apis.RPCError) {
+	var filter apis.LogFilter
+	json.Unmarshal(params[0], &filter)
+	from, _ := apis.ParseBlockNumber(filter.FromBlock)
+	to, _ := apis.ParseBlockNumber(filter.ToBlock)
+	u.mu.Lock()
+	u.ranges = append(u.ranges, [2]uint64{from, to})
+	u.mu.Unlock()
+	if to-from >= u.maxRange {
+		return nil, &apis.RPCError{Code: rpcLimitExceeded, Message: "query returned more than 10000 results"}
+	}
+	logs := []apis.Log{}
+	for n := from; ; n++ {
+		// reversed within the block, logsPage sorts by log index
+		for index := uint64(2); index > 0; index-- {
+			logs = append(logs, apis.Log{BlockNumber: apis.EncodeUint64(n), LogIndex: apis.EncodeUint64(index - 1)})
+		}
+		if n == to {
+			break
+		}
+	}
+	return logs, nil
+}
+
+func logPositions(logs []apis.Log) string {
+	positions := make([]string, len(logs))
+	for i, log := range logs {
+		block, _ := apis.ParseBlockNumber(log.BlockNumber)
+		index, _ := apis.ParseBlockNumber(log.LogIndex)
+		positions[i] = apis.EncodeUint64(block) + "/" + apis.EncodeUint64(index)
+	}
+	return strings.Join(positions, " ")
+}
+
+func TestLogsCursor(t *testing.T) {
+	cursor := logsCursor{Block: 12, Skip: 3, To: math.MaxUint64}
+	parsed, err := parseLogsCursor(cursor.String())
+	if err != nil || parsed != cursor {
+		t.Errorf("parseLogsCursor(%s) = %+v, %v, want %+v", cursor, parsed, err, cursor)
+	}
+	for _, invalid := range []string{
+		"not base64!",
+		logsCursor{Block: 12, To: 11}.String(),
+		logsCursor{Block: 1, Skip: -1, To: 2}.String(),
+		"MToy",
+	} {
+		if _, err := parseLogsCursor(invalid); err != errInvalidCursor {
+			t.Errorf("parseLogsCursor(%q) error = %v, want %v", invalid, err, errInvalidCursor)
+		}
+	}
+}
+
+func TestNextLogsCursor(t *testing.T) {
+	returned := []apis.Log{{BlockNumber: "0x4"}, {BlockNumber: "0x5"}, {BlockNumber: "0x5"}}
+	for _, test := range []struct {
+		name   string
+		block  uint64
+		cursor logsCursor
+		want   logsCursor
+	}{
+		{"new block", 6, logsCursor{Block: 1, To: 9}, logsCursor{Block: 6, To: 9}},
+		{"within block", 5, logsCursor{Block: 1, To: 9}, logsCursor{Block: 5, Skip: 2, To: 9}},
+		{"within cursor block", 5, logsCursor{Block: 5, Skip: 4, To: 9}, logsCursor{Block: 5, Skip: 6, To: 9}},
+	} {
+		if got := nextLogsCursor(returned, test.block, test.cursor); got != test.want {
+			t.Errorf("%s: nextLogsCursor() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLogsPagination(t *testing.T) {
+	upstream := &logsUpstream{maxRange: 100}
+	h := newTestHandler(t, upstream.rpc)
+	h.LogsChunkSize = 2
+
+	var pages []string
+	cursor := logsCursor{Block: 1, To: 4}
+	for {
+		page, err := h.logsPage(context.Background(), apis.LogFilter{}, cursor, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, logPositions(page.Logs))
+		if page.NextCursor == "" {
+			break
+		}
+		if cursor, err = parseLogsCursor(page.NextCursor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"0x1/0x0 0x1/0x1 0x2/0x0", "0x2/0x1 0x3/0x0 0x3/0x1", "0x4/0x0 0x4/0x1"}
+	if strings.Join(pages, " | ") != strings.Join(want, " | ") {
+		t.Errorf("pages = %q, want %q", pages, want)
+	}
+}
+
+func TestLogsPageEndsAtMaxBlock(t *testing.T) {
+	upstream := &logsUpstream{maxRange: 100}
+	h := newTestHandler(t, upstream.rpc)
+	h.LogsChunkSize = 2
+
+	page, err := h.logsPage(context.Background(), apis.LogFilter{}, logsCursor{Block: math.MaxUint64 - 2, To: math.MaxUint64}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Logs) != 6 || page.NextCursor != "" {
+		t.Errorf("logsPage() returned %d logs and cursor %q, want 6 logs and no cursor", len(page.Logs), page.NextCursor)
+	}
+	for _, rng := range upstream.ranges {
+		if rng[0] < math.MaxUint64-2 {
+			t.Errorf("requested range %v wrapped past the largest block", rng)
+		}
+	}
+}
+
+func TestFetchLogsHalvesRange(t *testing.T) {
+	upstream := &logsUpstream{maxRange: 3}
+	h := newTestHandler(t, upstream.rpc)
+
+	logs, err := h.fetchLogs(context.Background(), apis.LogFilter{}, 10, 19)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 20 {
+		t.Errorf("fetchLogs() returned %d logs, want 20", len(logs))
+	}
+	// 10-19 splits into 10-14 and 15-19, each again into ranges of at most 3 blocks
+	want := [][2]uint64{{10, 19}, {10, 14}, {10, 12}, {13, 14}, {15, 19}, {15, 17}, {18, 19}}
+	if len(upstream.ranges) != len(want) {
+		t.Fatalf("requested ranges %v, want %v", upstream.ranges, want)
+	}
+	for i := range want {
+		if upstream.ranges[i] != want[i] {
+			t.Errorf("requested ranges %v, want %v", upstream.ranges, want)
+			break
+		}
+	}
+
+	upstream.maxRange = 0
+	if _, err := h.fetchLogs(context.Background(), apis.LogFilter{}, 10, 10); err == nil {
+		t.Error("fetchLogs() of a single refused block succeeded, want the upstream error")
+	}
+}
+
+func TestGetLogsDecoded(t *testing.T) {
+	upstream := &logsUpstream{maxRange: 100}
+	h := newTestHandler(t, upstream.rpc)
+
+	req := httptest.NewRequest(http.MethodPost, "/logs?decode=true", strings.NewReader(`{"fromBlock":"0x10","toBlock":"0x10"}`))
+	rec := httptest.NewRecorder()
+	h.GetLogs(rec, req)
+	var resp struct {
+		Logs []struct {
+			BlockNumber uint64 `json:"blockNumber"`
+			LogIndex    uint64 `json:"logIndex"`
+		} `json:"logs"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response %s is not decoded: %v", rec.Body, err)
+	}
+	if len(resp.Logs) != 2 || resp.Logs[0].BlockNumber != 16 || resp.Logs[1].LogIndex != 1 {
+		t.Errorf("GetLogs() = %s, want the two logs of block 16 decoded", rec.Body)
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/jelias2/infra-test/src/apis"
+	"github.com/jelias2/infra-test/src/oracle"
+)
+
+/*
+* GetGasOracle summarizes the effective gas prices paid over the recent
+* blocks followed in the background. ?percentiles= overrides the configured
+* percentiles. The last summary is served while the upstreams are failing.
+ */
+func (h *Handler) GetGasOracle(w http.ResponseWriter, r *http.Request) {
+	percentiles := h.GasOracle.Percentiles
+	if list := r.URL.Query().Get("percentiles"); list != "" {
+		var err error
+		if percentiles, err = oracle.ParsePercentiles(list); err != nil {
+			h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()})
+			return
+		}
+	}
+	estimate, ok := h.GasOracle.Estimate(percentiles)
+	if !ok {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusServiceUnavailable, Message: "Gas price oracle has no blocks yet"})
+		return
+	}
+	h.writeDecoded(w, r, estimate, quantityNone)
+}
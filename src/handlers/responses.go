@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/jelias2/infra-test/src/apis"
+	"github.com/jelias2/infra-test/src/upstream"
+	"go.uber.org/zap"
+)
+
+// JSON-RPC error codes mapped to HTTP statuses
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcServerError    = -32000
+	rpcLimitExceeded  = -32005
+	rpcExecutionError = 3
+)
+
+const NotFoundMessage = "Not found"
+
+// WriteResponse encodes v as JSON, using the status code of an ErrorResponse as the HTTP status
+func (h *Handler) WriteResponse(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	status := http.StatusOK
+	switch e := v.(type) {
+	case *apis.ErrorResponse:
+		status = e.StatusCode
+	case apis.ErrorResponse:
+		status = e.StatusCode
+	}
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.Log.Error("Error encoding response", zap.Error(err))
+	}
+}
+
+/*
+* RPCResult unmarshals an upstream JSON-RPC response into result. When the
+* upstream answered with an error object or a null result, an ErrorResponse
+* carrying the JSON-RPC error is returned instead.
+ */
+func (h *Handler) RPCResult(resp []byte, result interface{}) interface{} {
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+		Error  *apis.RPCError  `json:"error"`
+	}
+	if err := json.Unmarshal(resp, &envelope); err != nil {
+		h.Log.Error("Error unmarshalling upstream response", zap.Error(err))
+		return &apis.ErrorResponse{StatusCode: http.StatusBadGateway, Message: "Invalid upstream response"}
+	}
+	if envelope.Error != nil {
+		return &apis.ErrorResponse{
+			StatusCode: rpcErrorStatus(envelope.Error),
+			Message:    envelope.Error.Message,
+			Error:      envelope.Error,
+		}
+	}
+	if len(envelope.Result) == 0 || string(envelope.Result) == "null" {
+		return &apis.ErrorResponse{StatusCode: http.StatusNotFound, Message: NotFoundMessage}
+	}
+	if err := json.Unmarshal(resp, result); err != nil {
+		h.Log.Error("Error unmarshalling upstream result", zap.Error(err))
+		return &apis.ErrorResponse{StatusCode: http.StatusBadGateway, Message: "Invalid upstream result"}
+	}
+	return result
+}
+
+// UpstreamError converts an error from the upstream pools into an ErrorResponse
+func (h *Handler) UpstreamError(err error) *apis.ErrorResponse {
+	var statusErr *upstream.StatusError
+	switch {
+	case errors.Is(err, upstream.ErrCircuitOpen), errors.Is(err, upstream.ErrNoConnection):
+		return &apis.ErrorResponse{
+			StatusCode: http.StatusServiceUnavailable,
+			Message:    "Upstream unavailable: " + err.Error(),
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &apis.ErrorResponse{StatusCode: http.StatusGatewayTimeout, Message: "Upstream timed out"}
+	case errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests:
+		return &apis.ErrorResponse{StatusCode: http.StatusTooManyRequests, Message: err.Error()}
+	}
+	return &apis.ErrorResponse{StatusCode: http.StatusBadGateway, Message: err.Error()}
+}
+
+// rpcErrorStatus picks the HTTP status for a JSON-RPC error returned by the upstream
+func rpcErrorStatus(e *apis.RPCError) int {
+	switch e.Code {
+	case rpcParseError, rpcInvalidRequest, rpcMethodNotFound, rpcInvalidParams, rpcExecutionError:
+		return http.StatusBadRequest
+	case rpcLimitExceeded:
+		return http.StatusTooManyRequests
+	case rpcServerError:
+		if strings.Contains(strings.ToLower(e.Message), "not found") {
+			return http.StatusNotFound
+		}
+	}
+	return http.StatusBadGateway
+}
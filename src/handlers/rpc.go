@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/jelias2/infra-test/src/apis"
+	"go.uber.org/zap"
+)
+
+const DefaultMaxBatchSize = 20
+
+// RPC forwards any allowlisted JSON-RPC 2.0 request or batch to the upstreams, preserving the caller's ids
+func (h *Handler) RPC(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		h.writeRPC(w, http.StatusBadRequest, rpcErrorResponse(nil, rpcParseError, err.Error()))
+		return
+	}
+	body = bytes.TrimSpace(body)
+	if !json.Valid(body) {
+		h.writeRPC(w, http.StatusBadRequest, rpcErrorResponse(nil, rpcParseError, "Parse error"))
+		return
+	}
+	if body[0] == '[' {
+		h.rpcBatch(w, r.Context(), body)
+		return
+	}
+
+	req, errResp := parseRPCRequest(body)
+	if errResp != nil {
+		h.writeRPC(w, http.StatusBadRequest, errResp)
+		return
+	}
+	resp, err := h.forwardRPC(r.Context(), req)
+	if err != nil {
+		h.Log.Error("Error forwarding rpc request", zap.String("Method", string(req.Method)), zap.Error(err))
+		upstreamErr := h.UpstreamError(err)
+		h.writeRPC(w, upstreamErr.StatusCode, rpcErrorResponse(req.ID, rpcInternalError, upstreamErr.Message))
+		return
+	}
+	if req.ID == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+/*
+* rpcBatch validates every request of a batch, splits the valid ones into
+* chunks of at most MaxBatchSize which are forwarded concurrently (and so may
+* land on different upstreams), then merges the responses in request order.
+ */
+func (h *Handler) rpcBatch(w http.ResponseWriter, ctx context.Context, body []byte) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(body, &raws); err != nil || len(raws) == 0 {
+		h.writeRPC(w, http.StatusBadRequest, rpcErrorResponse(nil, rpcInvalidRequest, "Empty batch"))
+		return
+	}
+
+	reqs := make([]apis.RPCRequest, len(raws))
+	responses := make([]*apis.RPCResponse, len(raws))
+	var forward []int
+	for i, raw := range raws {
+		req, errResp := parseRPCRequest(raw)
+		if errResp != nil {
+			responses[i] = errResp
+			continue
+		}
+		reqs[i] = req
+		forward = append(forward, i)
+	}
+
+	chunkSize := h.MaxBatchSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultMaxBatchSize
+	}
+	var wg sync.WaitGroup
+	for start := 0; start < len(forward); start += chunkSize {
+		end := start + chunkSize
+		if end > len(forward) {
+			end = len(forward)
+		}
+		wg.Add(1)
+		go func(chunk []int) {
+			defer wg.Done()
+			h.forwardBatch(ctx, reqs, chunk, responses)
+		}(forward[start:end])
+	}
+	wg.Wait()
+
+	merged := []*apis.RPCResponse{}
+	for i, resp := range responses {
+		if resp.Error == nil && reqs[i].ID == nil {
+			// notifications are forwarded but never answered
+			continue
+		}
+		merged = append(merged, resp)
+	}
+	if len(merged) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	h.writeRPC(w, http.StatusOK, merged)
+}
+
+/*
+* forwardBatch sends the requests at the chunk indexes upstream as a single
+* batch. Their ids are replaced with the request index on the wire so the
+* responses can be matched back regardless of duplicate or missing client
+* ids, and the original ids are restored on the way back.
+ */
+func (h *Handler) forwardBatch(ctx context.Context, reqs []apis.RPCRequest, chunk []int, responses []*apis.RPCResponse) {
+	wire := make([]apis.RPCRequest, 0, len(chunk))
+	for _, i := range chunk {
+		req := reqs[i]
+		req.ID = json.RawMessage(strconv.Itoa(i))
+		wire = append(wire, req)
+	}
+
+	fail := func(message string) {
+		for _, i := range chunk {
+			if responses[i] == nil {
+				responses[i] = rpcErrorResponse(reqs[i].ID, rpcInternalError, message)
+			}
+		}
+	}
+
+	resp, err := h.Upstreams.Post(ctx, wire, nil)
+	h.DebugResponse("RPCBatch", resp, err)
+	if err != nil {
+		fail(h.UpstreamError(err).Message)
+		return
+	}
+
+	var batch []apis.RPCResponse
+	if err := json.Unmarshal(resp.Body(), &batch); err != nil {
+		single := apis.RPCResponse{}
+		if json.Unmarshal(resp.Body(), &single) == nil && single.Error != nil {
+			fail(single.Error.Message)
+			return
+		}
+		fail("Invalid upstream batch response")
+		return
+	}
+
+	inChunk := make(map[int]bool, len(chunk))
+	for _, i := range chunk {
+		inChunk[i] = true
+	}
+	for j := range batch {
+		i, err := strconv.Atoi(string(batch[j].ID))
+		if err != nil || !inChunk[i] {
+			continue
+		}
+		batch[j].ID = reqs[i].ID
+		responses[i] = &batch[j]
+	}
+	fail("Missing upstream response")
+}
+
+// forwardRPC sends a single request upstream, serving block lookups through the block cache
+func (h *Handler) forwardRPC(ctx context.Context, req apis.RPCRequest) ([]byte, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	call := h.httpCall("RPC")
+	switch req.Method {
+	case apis.GetBlockByNumber:
+		return h.blockCall(ctx, body, call)
+	case apis.GetTransactionByBlockNumberAndIndex:
+		return h.txCall(ctx, body, call)
+	}
+	return call(ctx, body)
+}
+
+// parseRPCRequest validates a single JSON-RPC request against the method allowlist
+func parseRPCRequest(raw []byte) (apis.RPCRequest, *apis.RPCResponse) {
+	var req apis.RPCRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return req, rpcErrorResponse(nil, rpcInvalidRequest, "Invalid request: "+err.Error())
+	}
+	if req.JsonRPC != apis.RPCVersion2 || req.Method == "" {
+		return req, rpcErrorResponse(req.ID, rpcInvalidRequest, "Invalid request")
+	}
+	if !apis.AllowedMethods[req.Method] {
+		return req, rpcErrorResponse(req.ID, rpcMethodNotFound, "Method not allowed: "+string(req.Method))
+	}
+	return req, nil
+}
+
+func rpcErrorResponse(id json.RawMessage, code int, message string) *apis.RPCResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &apis.RPCResponse{
+		JsonRPC: apis.RPCVersion2,
+		ID:      id,
+		Error:   &apis.RPCError{Code: code, Message: message},
+	}
+}
+
+func (h *Handler) writeRPC(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.Log.Error("Error encoding rpc response", zap.Error(err))
+	}
+}
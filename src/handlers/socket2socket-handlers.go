@@ -2,120 +2,289 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/jelias2/infra-test/src/apis"
+	"github.com/jelias2/infra-test/src/upstream"
 	"go.uber.org/zap"
 )
 
+const DefaultMaxPendingRequests = 32
+const DefaultMaxSubscriptions = 16
+const clientWriteWait = 5 * time.Second
+
+// wsClient is a single downstream websocket connection whose writes may come from many goroutines
+type wsClient struct {
+	conn     *websocket.Conn
+	writeMu  sync.Mutex
+	mu       sync.Mutex
+	subs     map[string]*upstream.HubSubscription
+	reserved int
+	closed   bool
+}
+
+func (c *wsClient) write(msg []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(clientWriteWait))
+	return c.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+func (c *wsClient) writeJSON(v interface{}) error {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(msg)
+}
+
+// reserve claims one of the limit subscription slots of the client for a pending eth_subscribe
+func (c *wsClient) reserve(limit int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || len(c.subs)+c.reserved >= limit {
+		return false
+	}
+	c.reserved++
+	return true
+}
+
+// add fills a reserved slot with sub, reporting false when the client already disconnected
+func (c *wsClient) add(sub *upstream.HubSubscription) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reserved--
+	if c.closed {
+		return false
+	}
+	c.subs[sub.ID] = sub
+	return true
+}
+
+func (c *wsClient) release() {
+	c.mu.Lock()
+	c.reserved--
+	c.mu.Unlock()
+}
+
+func (c *wsClient) remove(id string) *upstream.HubSubscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sub := c.subs[id]
+	delete(c.subs, id)
+	return sub
+}
+
+// unsubscribeAll cancels every subscription of a disconnected client
+func (c *wsClient) unsubscribeAll() []error {
+	c.mu.Lock()
+	c.closed = true
+	subs := c.subs
+	c.subs = map[string]*upstream.HubSubscription{}
+	c.mu.Unlock()
+	var errs []error
+	for _, sub := range subs {
+		if err := sub.Unsubscribe(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+/*
+* Socket2socket proxies JSON-RPC requests from a client websocket to the
+* shared upstream websocket pool. Each request is forwarded in its own
+* goroutine, up to MaxPendingRequests at a time per client, and its response
+* is written back as soon as it arrives, with the client's id restored, so
+* pipelined requests may be answered out of order.
+* eth_subscribe notifications are pushed to the client as they arrive, and
+* every subscription of the client is cancelled upstream when it disconnects.
+ */
 func (h *Handler) Socket2socket(w http.ResponseWriter, r *http.Request) {
+	client := h.UpgradeConnection(w, r)
+	if client == nil {
+		return
+	}
 
-	var infuraReq []byte
-	var ok bool
-	infuraClient, clientConn := h.UpgradeConnection(w, r)
+	ctx, cancel := context.WithCancel(context.Background())
+	limit := h.MaxPendingRequests
+	if limit <= 0 {
+		limit = DefaultMaxPendingRequests
+	}
+	pending := make(chan struct{}, limit)
+	var wg sync.WaitGroup
 
 	for {
-		var err error
-
-		_, msg, err := clientConn.ReadMessage()
+		_, msg, err := client.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				h.Log.Error("Client closed unexpecttedly")
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				h.Log.Error("Client closed unexpecttedly", zap.Error(err))
 			} else {
-				h.Log.Error("Failed to read message from websocket client", zap.Error(err))
+				h.Log.Info("Websocket client disconnected", zap.Error(err))
 			}
 			break
 		}
 		h.Log.Info("Recieved Websocket Message", zap.String("Message", string(msg)))
-		if bytes.Contains(msg, []byte("true")) || bytes.Contains(msg, []byte("false")) {
-			if infuraReq, ok = h.formatInfuraBooeanRequestMsg(msg); !ok {
-				clientConn.WriteMessage(websocket.TextMessage, infuraReq)
-				continue
-			}
-		} else {
-			if infuraReq, ok = h.formatInfuraRequestMsg(msg); !ok {
-				clientConn.WriteMessage(websocket.TextMessage, []byte("Failed to unmarshalll message client"))
-				continue
-			}
+
+		req, errResp := parseSocketRequest(msg)
+		if errResp != nil {
+			client.writeJSON(errResp)
+			continue
 		}
 
-		if ok {
-			clientResp := h.WriteAndReadToInfura(infuraClient, infuraReq)
-			h.Log.Info("Writing Client websocket message", zap.ByteString("Response", clientResp))
-			if err = clientConn.WriteMessage(websocket.TextMessage, clientResp); err != nil {
-				h.Log.Info("Error wrting client message", zap.Error(err))
-				break
+		pending <- struct{}{}
+		wg.Add(1)
+		go func(req apis.RPCRequest, msg []byte) {
+			defer wg.Done()
+			var sub *upstream.HubSubscription
+			switch req.Method {
+			case apis.Subscribe:
+				sub = h.socketSubscribe(ctx, client, req)
+			case apis.Unsubscribe:
+				h.socketUnsubscribe(client, req)
+			default:
+				h.forwardSocketRequest(ctx, client, req, msg)
 			}
-		}
+			<-pending
+			if sub != nil {
+				h.forwardNotifications(client, sub)
+			}
+		}(req, msg)
 	}
 
-	infuraClient.Close()
-	clientConn.Close()
+	cancel()
+	for _, err := range client.unsubscribeAll() {
+		h.Log.Info("Error cancelling upstream subscription", zap.Error(err))
+	}
+	wg.Wait()
+	client.conn.Close()
 }
 
-func (h *Handler) UpgradeConnection(w http.ResponseWriter, r *http.Request) (*websocket.Conn, *websocket.Conn) {
+// UpgradeConnection upgrades the client request to a websocket, returning nil when the upgrade failed
+func (h *Handler) UpgradeConnection(w http.ResponseWriter, r *http.Request) *wsClient {
 	upgrader := websocket.Upgrader{}
 	clientConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		json.NewEncoder(w).Encode(&apis.ErrorResponse{
-			StatusCode: http.StatusUpgradeRequired,
-			Message:    "Error Upgrading to WebSocket connection",
-		})
+		h.Log.Error("Error Upgrading to WebSocket connection", zap.Error(err))
+		return nil
 	}
+	return &wsClient{conn: clientConn, subs: map[string]*upstream.HubSubscription{}}
+}
 
-	mainnetWebsocketEndpoint := h.Mainnet_websocket_endpoint
-	infuraClient, _, err := websocket.DefaultDialer.Dial(mainnetWebsocketEndpoint, nil)
+// forwardSocketRequest sends a single client request over the upstream pool and writes back the response
+func (h *Handler) forwardSocketRequest(ctx context.Context, client *wsClient, req apis.RPCRequest, msg []byte) {
+	resp, err := h.WsPool.Call(ctx, msg)
 	if err != nil {
-		log.Fatal("Fatal Dial Error:", zap.Error(err))
-		clientConn.WriteMessage(websocket.CloseMessage, []byte("Failed to unmarshalll message client"))
-		clientConn.Close()
+		if ctx.Err() != nil {
+			return
+		}
+		h.Log.Error("Error forwarding websocket request", zap.String("Method", string(req.Method)), zap.Error(err))
+		resp, _ = json.Marshal(rpcErrorResponse(req.ID, rpcInternalError, h.UpstreamError(err).Message))
+	}
+	if req.ID == nil {
+		return
+	}
+	h.Log.Info("Writing Client websocket message", zap.ByteString("Response", resp))
+	if err := client.write(resp); err != nil {
+		h.Log.Info("Error wrting client message", zap.Error(err))
 	}
-	return infuraClient, clientConn
 }
 
-func (h *Handler) WriteAndReadToInfura(infuraClient *websocket.Conn, infuraReq []byte) []byte {
-	var err error
-	var infuraResp []byte
-	if err = infuraClient.WriteMessage(websocket.TextMessage, infuraReq); err != nil {
-		h.Log.Info("Error writing Infura websocket message", zap.Error(err))
-		return []byte("Error writing Infura websocket message")
+// socketSubscribe joins the shared subscription for the request params, returning nil when it was refused
+func (h *Handler) socketSubscribe(ctx context.Context, client *wsClient, req apis.RPCRequest) *upstream.HubSubscription {
+	limit := h.MaxSubscriptions
+	if limit <= 0 {
+		limit = DefaultMaxSubscriptions
 	}
-
-	if _, infuraResp, err = infuraClient.ReadMessage(); err != nil {
-		h.Log.Info("Error reading Infura websocket message", zap.Error(err))
-		return []byte("Error reading Infura websocket message")
+	if !client.reserve(limit) {
+		client.writeJSON(rpcErrorResponse(req.ID, rpcLimitExceeded, "Subscription limit of "+strconv.Itoa(limit)+" reached"))
+		return nil
 	}
-	return infuraResp
-
+	sub, err := h.Hub.Subscribe(ctx, req.Params)
+	if err != nil {
+		client.release()
+		h.Log.Error("Error subscribing upstream", zap.ByteString("Params", req.Params), zap.Error(err))
+		var rpcErr *apis.RPCError
+		if errors.As(err, &rpcErr) {
+			client.writeJSON(&apis.RPCResponse{JsonRPC: apis.RPCVersion2, ID: req.ID, Error: rpcErr})
+		} else {
+			client.writeJSON(rpcErrorResponse(req.ID, rpcInternalError, h.UpstreamError(err).Message))
+		}
+		return nil
+	}
+	if !client.add(sub) {
+		sub.Unsubscribe()
+		return nil
+	}
+	result, _ := json.Marshal(sub.ID)
+	client.writeJSON(&apis.RPCResponse{JsonRPC: apis.RPCVersion2, ID: req.ID, Result: result})
+	return sub
 }
 
-// eth_getBlockByNumber, eth_getBlockByHash contain boolean, have len 2 params array
-func (h *Handler) formatInfuraBooeanRequestMsg(body []byte) ([]byte, bool) {
-	body = bytes.Replace(body, []byte("true"), []byte("\"true\""), 1)
-	body = bytes.Replace(body, []byte("false"), []byte("\"false\""), 1)
-	reqBody := &apis.InfuraRequestBody{}
-	if err := json.Unmarshal(body, reqBody); err != nil {
-		h.Log.Info("Error Parsing boolean websocket message", zap.Error(err))
-		return []byte("Error Parsing boolean websocket message"), false
-	}
-	body = []byte(fmt.Sprintf(apis.BooleanRequestBodyTemplate, reqBody.Method, reqBody.Params[0], reqBody.Params[1]))
-	return body, true
+// socketUnsubscribe cancels one of the client's subscriptions, answering false for unknown ids
+func (h *Handler) socketUnsubscribe(client *wsClient, req apis.RPCRequest) {
+	var ids []string
+	if err := json.Unmarshal(req.Params, &ids); err != nil || len(ids) != 1 {
+		client.writeJSON(rpcErrorResponse(req.ID, rpcInvalidParams, "Expected a single subscription id"))
+		return
+	}
+	result := json.RawMessage("false")
+	if sub := client.remove(ids[0]); sub != nil {
+		if err := sub.Unsubscribe(); err != nil {
+			h.Log.Info("Error cancelling upstream subscription", zap.String("Subscription", sub.ID), zap.Error(err))
+		}
+		result = json.RawMessage("true")
+	}
+	if req.ID != nil {
+		client.writeJSON(&apis.RPCResponse{JsonRPC: apis.RPCVersion2, ID: req.ID, Result: result})
+	}
 }
 
-func (h *Handler) formatInfuraRequestMsg(clientReq []byte) ([]byte, bool) {
-	var reqBody = &apis.InfuraRequestBody{}
-	var msg []byte
-	var err error
-	if err = json.Unmarshal(clientReq, reqBody); err != nil {
-		return []byte("Failed to unmarshall request message for Infura"), false
+/*
+* forwardNotifications writes the notifications of sub to the client until it
+* is unsubscribed. A client the hub dropped for falling behind is disconnected.
+ */
+func (h *Handler) forwardNotifications(client *wsClient, sub *upstream.HubSubscription) {
+	for result := range sub.C {
+		client.writeJSON(&apis.RPCNotification{
+			JsonRPC: apis.RPCVersion2,
+			Method:  apis.SubscriptionNotification,
+			Params:  apis.SubscriptionResult{Subscription: sub.ID, Result: result},
+		})
+	}
+	if errors.Is(sub.Err(), upstream.ErrSlowConsumer) {
+		h.Log.Info("Disconnecting slow websocket client", zap.String("Subscription", sub.ID))
+		client.writeMu.Lock()
+		client.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, upstream.ErrSlowConsumer.Error()),
+			time.Now().Add(clientWriteWait))
+		client.writeMu.Unlock()
+		client.conn.Close()
 	}
+}
 
-	if msg, err = json.Marshal(h.CreateRequestBody(reqBody.Method, reqBody.Params)); err != nil {
-		return []byte("Failed to create formmated request message for Infura"), false
+// parseSocketRequest validates a single JSON-RPC request read from a client websocket
+func parseSocketRequest(msg []byte) (apis.RPCRequest, *apis.RPCResponse) {
+	var req apis.RPCRequest
+	msg = bytes.TrimSpace(msg)
+	if !json.Valid(msg) {
+		return req, rpcErrorResponse(nil, rpcParseError, "Parse error")
+	}
+	if msg[0] == '[' {
+		return req, rpcErrorResponse(nil, rpcInvalidRequest, "Batch requests are not supported over websocket, use POST /rpc")
+	}
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return req, rpcErrorResponse(nil, rpcInvalidRequest, "Invalid request: "+err.Error())
+	}
+	if req.JsonRPC != apis.RPCVersion2 || req.Method == "" {
+		return req, rpcErrorResponse(req.ID, rpcInvalidRequest, "Invalid request")
 	}
-	return msg, true
+	return req, nil
 }
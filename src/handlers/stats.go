@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+/*
+* GetChainStats reports block time, throughput, gas usage and base fee
+* movement over the last ?window= blocks (default and at most the
+* configured STATS_WINDOW), maintained by the background head follower.
+ */
+func (h *Handler) GetChainStats(w http.ResponseWriter, r *http.Request) {
+	window := h.ChainStats.Window
+	if raw := r.URL.Query().Get("window"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > h.ChainStats.Window {
+			h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("window must be a number of blocks between 1 and %d", h.ChainStats.Window)})
+			return
+		}
+		window = n
+	}
+	summary, ok := h.ChainStats.Summary(window)
+	if !ok {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusServiceUnavailable, Message: "Chain statistics have no blocks yet"})
+		return
+	}
+	h.writeDecoded(w, r, summary, quantityNone)
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jelias2/infra-test/src/apis"
+	"go.uber.org/zap"
+)
+
+const DefaultStreamInterval = 2 * time.Second
+const keepAliveInterval = 15 * time.Second
+
+// streamHistory is how many block events are kept for Last-Event-ID resume, older gaps are fetched again
+const streamHistory = 128
+
+// maxStreamCatchUp bounds how many missed blocks are sent when the head jumps or a client resumes
+const maxStreamCatchUp = 64
+
+// streamBuffer is how many events a client may fall behind before it is disconnected
+const streamBuffer = 32
+
+type sseEvent struct {
+	ID     string
+	Event  string
+	Data   []byte
+	block  uint64
+	rewind *uint64
+}
+
+/*
+* sseFeed broadcasts the events of a stream to every client. A feed with a
+* poll function polls the upstream on behalf of its clients, polling starts
+* with the first client and stops once the last one leaves. The block feed is
+* published to by the head follower instead. Recent events are kept so new
+* and resuming clients can be caught up.
+ */
+type sseFeed struct {
+	h       *Handler
+	poll    func(ctx context.Context)
+	history int
+
+	mu      sync.Mutex
+	clients map[chan sseEvent]bool
+	events  []sseEvent
+	running bool
+}
+
+func (h *Handler) streamFeeds() (*sseFeed, *sseFeed) {
+	h.streamsOnce.Do(func() {
+		h.blockFeed = &sseFeed{h: h, history: streamHistory, clients: map[chan sseEvent]bool{}}
+		h.gasFeed = &sseFeed{h: h, history: 1, clients: map[chan sseEvent]bool{}}
+		h.gasFeed.poll = h.gasFeed.pollGasPrice
+	})
+	return h.blockFeed, h.gasFeed
+}
+
+/*
+* StreamBlocks pushes a "block" event with the block header whenever a new
+* head arrives. The event id is the decimal block number, a client
+* reconnecting with Last-Event-ID receives the blocks it missed. When the
+* head follower detects a reorg a "reorg" event is sent and the blocks of
+* the new chain follow.
+ */
+func (h *Handler) StreamBlocks(w http.ResponseWriter, r *http.Request) {
+	blockFeed, _ := h.streamFeeds()
+	if _, ok := h.Chain.Head(); !ok {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusServiceUnavailable, Message: "Head follower has no blocks yet"})
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	var resume *uint64
+	if lastID != "" {
+		number, ok := apis.ParseBlockNumber(lastID)
+		if !ok {
+			h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: "Last-Event-ID must be a block number"})
+			return
+		}
+		resume = &number
+	}
+	h.serveStream(w, r, blockFeed, resume)
+}
+
+// StreamGasPrice pushes a "gasprice" event with the current gas price whenever it changes
+func (h *Handler) StreamGasPrice(w http.ResponseWriter, r *http.Request) {
+	_, gasFeed := h.streamFeeds()
+	h.serveStream(w, r, gasFeed, nil)
+}
+
+func (h *Handler) serveStream(w http.ResponseWriter, r *http.Request, f *sseFeed, resume *uint64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusInternalServerError, Message: "Streaming unsupported"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch, backlog := f.subscribe(resume)
+	defer f.unsubscribe(ch)
+	if resume != nil {
+		backlog = append(h.missedBlocks(r.Context(), *resume, backlog), backlog...)
+	}
+	// block events already sent are skipped, a resumed backlog may overlap with the live feed
+	var sent uint64
+	if resume != nil {
+		sent = *resume
+	}
+	for _, ev := range backlog {
+		writeEvent(w, ev)
+		if ev.block > sent {
+			sent = ev.block
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				h.Log.Info("Closing slow stream client")
+				return
+			}
+			if ev.block != 0 && ev.block <= sent {
+				continue
+			}
+			writeEvent(w, ev)
+			flusher.Flush()
+			if ev.rewind != nil && *ev.rewind < sent {
+				sent = *ev.rewind
+			}
+			if ev.block > sent {
+				sent = ev.block
+			}
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, ev sseEvent) {
+	if ev.ID != "" {
+		fmt.Fprintf(w, "id: %s\n", ev.ID)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Event, ev.Data)
+}
+
+/*
+* subscribe registers a client and returns the events it should be sent
+* first: everything after resume when resuming, otherwise only the latest
+* event so the client starts from the current state.
+ */
+func (f *sseFeed) subscribe(resume *uint64) (chan sseEvent, []sseEvent) {
+	ch := make(chan sseEvent, streamBuffer)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.clients[ch] = true
+	if !f.running && f.poll != nil {
+		f.running = true
+		go f.run()
+	}
+
+	var backlog []sseEvent
+	switch {
+	case resume != nil:
+		for _, ev := range f.events {
+			if ev.block > *resume {
+				backlog = append(backlog, ev)
+			}
+		}
+	case len(f.events) > 0:
+		backlog = append(backlog, f.events[len(f.events)-1])
+	}
+	return ch, backlog
+}
+
+func (f *sseFeed) unsubscribe(ch chan sseEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.clients[ch] {
+		delete(f.clients, ch)
+		close(ch)
+	}
+}
+
+// publish records ev and sends it to every client, a client whose buffer is full is dropped
+func (f *sseFeed) publish(ev sseEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, ev)
+	if len(f.events) > f.history {
+		f.events = f.events[len(f.events)-f.history:]
+	}
+	f.broadcast(ev)
+}
+
+/*
+* rewind forgets the block events after ancestor and sends ev to every
+* client, which resend the blocks after ancestor as the feed publishes the
+* new chain.
+ */
+func (f *sseFeed) rewind(ancestor uint64, ev sseEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keep := len(f.events)
+	for keep > 0 && f.events[keep-1].block > ancestor {
+		keep--
+	}
+	f.events = f.events[:keep]
+	ev.rewind = &ancestor
+	f.broadcast(ev)
+}
+
+// broadcast sends ev to every client, a client whose buffer is full is dropped, f.mu must be held
+func (f *sseFeed) broadcast(ev sseEvent) {
+	for ch := range f.clients {
+		select {
+		case ch <- ev:
+		default:
+			delete(f.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+func (f *sseFeed) last() (sseEvent, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.events) == 0 {
+		return sseEvent{}, false
+	}
+	return f.events[len(f.events)-1], true
+}
+
+// run polls until the feed has no clients left
+func (f *sseFeed) run() {
+	interval := f.h.StreamInterval
+	if interval <= 0 {
+		interval = DefaultStreamInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval*5)
+		f.poll(ctx)
+		cancel()
+		<-ticker.C
+
+		f.mu.Lock()
+		if len(f.clients) == 0 {
+			f.running = false
+			f.mu.Unlock()
+			return
+		}
+		f.mu.Unlock()
+	}
+}
+
+// pollGasPrice publishes the gas price when it differs from the last published one
+func (f *sseFeed) pollGasPrice(ctx context.Context) {
+	body, _ := json.Marshal(f.h.CreateRequestBody(apis.GetGasPrice, apis.Params()))
+	resp, err := f.h.httpCall("StreamGasPrice")(ctx, body)
+	if err != nil {
+		f.h.Log.Info("Error polling gas price for stream", zap.Error(err))
+		return
+	}
+	result := &apis.GetGasPriceResponse{}
+	if err := json.Unmarshal(resp, result); err != nil || result.Error != nil || result.Result == "" {
+		return
+	}
+	data, _ := json.Marshal(apis.GasPriceEvent{GasPrice: result.Result})
+	if last, ok := f.last(); ok && string(last.Data) == string(data) {
+		return
+	}
+	f.publish(sseEvent{Event: "gasprice", Data: data})
+}
+
+/*
+* publishBlock sends a block followed by the head follower to the block
+* stream, as the header with transaction hashes eth_getBlockByNumber returns.
+ */
+func (h *Handler) publishBlock(block *apis.BlockTxDetails) {
+	number, ok := apis.ParseBlockNumber(block.Number)
+	if !ok {
+		return
+	}
+	header := apis.BlockNoTxDetails{BlockHeader: block.BlockHeader, Transactions: []string{}, Extra: block.Extra}
+	for _, tx := range block.Transactions {
+		header.Transactions = append(header.Transactions, tx.Hash)
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		h.Log.Info("Error encoding block for stream", zap.String("Block", block.Number), zap.Error(err))
+		return
+	}
+	blockFeed, _ := h.streamFeeds()
+	blockFeed.publish(sseEvent{ID: strconv.FormatUint(number, 10), Event: "block", Data: data, block: number})
+}
+
+// blockEvent fetches the header of block n through the block cache
+func (h *Handler) blockEvent(ctx context.Context, n uint64) (sseEvent, bool) {
+	body, _ := json.Marshal(h.CreateRequestBody(apis.GetBlockByNumber, apis.Params(apis.EncodeUint64(n), false)))
+	resp, err := h.blockCall(ctx, body, h.httpCall("StreamBlocks"))
+	if err != nil || !hasResult(resp) {
+		h.Log.Info("Error fetching block for stream", zap.Uint64("Block", n), zap.Error(err))
+		return sseEvent{}, false
+	}
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+	}
+	json.Unmarshal(resp, &envelope)
+	return sseEvent{ID: strconv.FormatUint(n, 10), Event: "block", Data: envelope.Result, block: n}, true
+}
+
+/*
+* missedBlocks fetches the blocks after resume that are older than the
+* backlog taken from the feed history, at most maxStreamCatchUp of them.
+ */
+func (h *Handler) missedBlocks(ctx context.Context, resume uint64, backlog []sseEvent) []sseEvent {
+	var until uint64
+	if len(backlog) > 0 {
+		until = backlog[0].block
+	} else {
+		if head, ok := h.Chain.Head(); ok {
+			until = head.Number + 1
+		}
+	}
+	if until <= resume+1 {
+		return nil
+	}
+	from := resume + 1
+	if until-from > maxStreamCatchUp {
+		from = until - maxStreamCatchUp
+	}
+	var missed []sseEvent
+	for n := from; n < until; n++ {
+		ev, ok := h.blockEvent(ctx, n)
+		if !ok {
+			break
+		}
+		missed = append(missed, ev)
+	}
+	return missed
+}
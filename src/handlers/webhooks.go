@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jelias2/infra-test/src/apis"
+	"github.com/jelias2/infra-test/src/webhooks"
+	"go.uber.org/zap"
+)
+
+/*
+* CreateWebhook registers a webhook for the blocks the head follower follows
+* from now on. Blocks replaced by a reorg after their delivery are reported
+* to the webhook as orphaned.
+ */
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req apis.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	hook, err := webhooks.NewWebhook(req)
+	if err != nil {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+	head, ok := h.Chain.Head()
+	if !ok {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusServiceUnavailable, Message: "Head follower has no blocks yet"})
+		return
+	}
+	hook.After = head.Number
+	h.Webhooks.Add(hook)
+	h.Log.Info("Registered webhook", zap.String("Webhook", hook.ID), zap.String("URL", hook.URL))
+
+	view := hook.View()
+	view.Secret = hook.Secret
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(view)
+}
+
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	views := []apis.Webhook{}
+	for _, hook := range h.Webhooks.List() {
+		views = append(views, hook.View())
+	}
+	h.WriteResponse(w, views)
+}
+
+func (h *Handler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := h.Webhooks.Get(mux.Vars(r)["id"])
+	if !ok {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Webhook not found"})
+		return
+	}
+	h.WriteResponse(w, hook.View())
+}
+
+// DeleteWebhook removes a webhook, cancelling its pending deliveries
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !h.Webhooks.Remove(id) {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Webhook not found"})
+		return
+	}
+	h.WebhookDispatcher.Forget(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetWebhookDeliveries returns the delivery log of a webhook, most recent first
+func (h *Handler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := h.Webhooks.Get(id); !ok {
+		h.WriteResponse(w, &apis.ErrorResponse{StatusCode: http.StatusNotFound, Message: "Webhook not found"})
+		return
+	}
+	h.WriteResponse(w, h.WebhookDispatcher.Deliveries(id))
+}
+
+// notifyWebhooks dispatches the transactions of a block followed by the head follower to the webhooks they match
+func (h *Handler) notifyWebhooks(block *apis.BlockTxDetails) {
+	if h.Webhooks == nil {
+		return
+	}
+	for _, hook := range h.Webhooks.List() {
+		if txs := hook.Match(block); len(txs) > 0 {
+			h.WebhookDispatcher.Dispatch(hook, block, txs)
+		}
+	}
+}
+
+// orphanWebhooks tells the webhooks about delivered blocks a reorg replaced
+func (h *Handler) orphanWebhooks(reorg apis.ReorgEvent) {
+	if h.Webhooks == nil {
+		return
+	}
+	for _, hook := range h.Webhooks.List() {
+		h.WebhookDispatcher.Orphan(hook, reorg.Removed)
+	}
+}
@@ -0,0 +1,25 @@
+package keccak
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestSum256(t *testing.T) {
+	for _, test := range []struct {
+		data, want string
+	}{
+		{"", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{"abc", "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		{"The quick brown fox jumps over the lazy dog", "4d741b6f1eb29cb2a9b9911c82f56fa8d73b04959d3d9d222895df6c0b28aa15"},
+		{"Transfer(address,address,uint256)", "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"},
+	} {
+		digest := Sum256([]byte(test.data))
+		if got := hex.EncodeToString(digest[:]); got != test.want {
+			t.Errorf("Sum256(%q) = %s, want %s", test.data, got, test.want)
+		}
+		if got := Hex([]byte(test.data)); got != "0x"+test.want {
+			t.Errorf("Hex(%q) = %s, want 0x%s", test.data, got, test.want)
+		}
+	}
+}
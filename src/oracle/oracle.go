@@ -0,0 +1,196 @@
+package oracle
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+const DefaultWindow = 20
+
+// MaxWindow bounds how many blocks of gas prices are kept in memory
+const MaxWindow = 1024
+
+var DefaultPercentiles = []float64{10, 25, 50, 75, 90}
+
+var ErrInvalidPercentiles = errors.New("percentiles must be a comma separated list of numbers between 0 and 100")
+var ErrInvalidBlock = errors.New("block is missing its number, gas used or gas limit")
+
+// blockSample is what the oracle keeps of a block: the sorted effective gas prices of its transactions
+type blockSample struct {
+	number      uint64
+	prices      []*big.Int
+	utilization float64
+	baseFee     string
+}
+
+/*
+* Oracle keeps the effective gas prices of the last Window blocks in memory
+* and summarizes them on request. It is fed by a head follower, so the last
+* summary stays available while the upstreams are unreachable.
+ */
+type Oracle struct {
+	Window      int
+	Percentiles []float64
+
+	mu        sync.Mutex
+	blocks    []blockSample
+	updatedAt time.Time
+}
+
+func New(window int, percentiles []float64) *Oracle {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	if window > MaxWindow {
+		window = MaxWindow
+	}
+	if len(percentiles) == 0 {
+		percentiles = DefaultPercentiles
+	}
+	return &Oracle{Window: window, Percentiles: percentiles}
+}
+
+// ParsePercentiles parses a comma separated list such as "10,50,90", an empty list gives the defaults
+func ParsePercentiles(list string) ([]float64, error) {
+	if strings.TrimSpace(list) == "" {
+		return DefaultPercentiles, nil
+	}
+	var percentiles []float64
+	for _, field := range strings.Split(list, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, ErrInvalidPercentiles
+		}
+		percentiles = append(percentiles, p)
+	}
+	sort.Float64s(percentiles)
+	return percentiles, nil
+}
+
+// Head returns the number of the newest block the oracle has seen
+func (o *Oracle) Head() (uint64, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.blocks) == 0 {
+		return 0, false
+	}
+	return o.blocks[len(o.blocks)-1].number, true
+}
+
+/*
+* Add records the gas prices of block. A block at or below the newest one
+* replaces it and everything after it, so a reorganized block can be added
+* again. Blocks that fall out of the window are dropped.
+ */
+func (o *Oracle) Add(block *apis.BlockTxDetails) error {
+	number, ok := apis.ParseQuantity(block.Number)
+	gasUsed, okUsed := apis.ParseQuantity(block.GasUsed)
+	gasLimit, okLimit := apis.ParseQuantity(block.GasLimit)
+	if !ok || !okUsed || !okLimit {
+		return ErrInvalidBlock
+	}
+	sample := blockSample{number: number.Uint64(), baseFee: block.BaseFeePerGas}
+	if gasLimit.Sign() > 0 {
+		sample.utilization, _ = new(big.Rat).SetFrac(gasUsed, gasLimit).Float64()
+	}
+	baseFee, _ := apis.ParseQuantity(block.BaseFeePerGas)
+	for i := range block.Transactions {
+		if price, ok := effectiveGasPrice(&block.Transactions[i], baseFee); ok {
+			sample.prices = append(sample.prices, price)
+		}
+	}
+	sort.Slice(sample.prices, func(i, j int) bool { return sample.prices[i].Cmp(sample.prices[j]) < 0 })
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	keep := len(o.blocks)
+	for keep > 0 && o.blocks[keep-1].number >= sample.number {
+		keep--
+	}
+	o.blocks = append(o.blocks[:keep], sample)
+	drop := 0
+	for drop < len(o.blocks) && o.blocks[drop].number+uint64(o.Window) <= sample.number {
+		drop++
+	}
+	o.blocks = append([]blockSample(nil), o.blocks[drop:]...)
+	o.updatedAt = time.Now().UTC()
+	return nil
+}
+
+// Estimate summarizes the window at the given percentiles, it fails until a block was added
+func (o *Oracle) Estimate(percentiles []float64) (*apis.GasOracle, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.blocks) == 0 {
+		return nil, false
+	}
+	newest := o.blocks[len(o.blocks)-1]
+	estimate := &apis.GasOracle{
+		BlockNumber:   apis.EncodeUint64(newest.number),
+		OldestBlock:   apis.EncodeUint64(o.blocks[0].number),
+		Window:        o.Window,
+		Blocks:        len(o.blocks),
+		Percentiles:   []apis.GasPricePercentile{},
+		BaseFeePerGas: newest.baseFee,
+		UpdatedAt:     o.updatedAt.Format(time.RFC3339),
+	}
+	var prices []*big.Int
+	for _, block := range o.blocks {
+		prices = append(prices, block.prices...)
+		estimate.Utilization += block.utilization
+	}
+	estimate.Utilization /= float64(len(o.blocks))
+	estimate.Transactions = len(prices)
+	if len(prices) == 0 {
+		return estimate, true
+	}
+
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Cmp(prices[j]) < 0 })
+	estimate.MinGasPrice = apis.EncodeBig(prices[0])
+	estimate.MedianGasPrice = apis.EncodeBig(percentile(prices, 50))
+	estimate.MaxGasPrice = apis.EncodeBig(prices[len(prices)-1])
+	for _, p := range percentiles {
+		estimate.Percentiles = append(estimate.Percentiles, apis.GasPricePercentile{Percentile: p, GasPrice: apis.EncodeBig(percentile(prices, p))})
+	}
+	return estimate, true
+}
+
+/*
+* effectiveGasPrice is the price per gas a transaction paid: its gas price,
+* or for EIP-1559 style transactions the base fee plus the priority fee
+* capped at the max fee.
+ */
+func effectiveGasPrice(tx *apis.Transaction, baseFee *big.Int) (*big.Int, bool) {
+	if tx.MaxFeePerGas != "" && baseFee != nil {
+		maxFee, okFee := apis.ParseQuantity(tx.MaxFeePerGas)
+		tip, okTip := apis.ParseQuantity(tx.MaxPriorityFeePerGas)
+		if okFee && okTip {
+			price := new(big.Int).Add(baseFee, tip)
+			if price.Cmp(maxFee) > 0 {
+				price = maxFee
+			}
+			return price, true
+		}
+	}
+	return apis.ParseQuantity(tx.GasPrice)
+}
+
+// percentile picks the nearest rank value of the sorted prices
+func percentile(sorted []*big.Int, p float64) *big.Int {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
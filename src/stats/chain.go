@@ -0,0 +1,179 @@
+package stats
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+const DefaultWindow = 100
+
+// MaxWindow bounds how many blocks of statistics are kept in memory
+const MaxWindow = 10000
+
+var ErrInvalidBlock = errors.New("block is missing its number, timestamp, gas used or gas limit")
+
+// Base fee trends
+const (
+	TrendRising  = "rising"
+	TrendFalling = "falling"
+	TrendFlat    = "flat"
+)
+
+/*
+* blockStats is what is kept of a block. The running totals include the
+* block itself, so the totals of any range of blocks are the difference of
+* the totals at its ends.
+ */
+type blockStats struct {
+	number    uint64
+	timestamp uint64
+	baseFee   *big.Int
+	txs       int
+	uncles    int
+	empty     int
+	ratio     float64
+
+	totalTxs    int
+	totalUncles int
+	totalEmpty  int
+	totalRatio  float64
+}
+
+/*
+* Chain keeps per block statistics of the last Window blocks, updated one
+* block at a time by a head follower. Summaries over any number of the
+* newest blocks are computed from the running totals without visiting the
+* blocks in between.
+ */
+type Chain struct {
+	Window int
+
+	mu     sync.Mutex
+	blocks []blockStats
+}
+
+func NewChain(window int) *Chain {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	if window > MaxWindow {
+		window = MaxWindow
+	}
+	return &Chain{Window: window}
+}
+
+// Head returns the number of the newest block seen
+func (c *Chain) Head() (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.blocks) == 0 {
+		return 0, false
+	}
+	return c.blocks[len(c.blocks)-1].number, true
+}
+
+/*
+* Add records block. A block at or below the newest one replaces it and
+* everything after it, so a reorganized block can be added again. Blocks
+* that fall out of the window are dropped.
+ */
+func (c *Chain) Add(block *apis.BlockTxDetails) error {
+	number, okNumber := apis.ParseQuantity(block.Number)
+	timestamp, okTime := apis.ParseQuantity(block.Timestamp)
+	gasUsed, okUsed := apis.ParseQuantity(block.GasUsed)
+	gasLimit, okLimit := apis.ParseQuantity(block.GasLimit)
+	if !okNumber || !okTime || !okUsed || !okLimit {
+		return ErrInvalidBlock
+	}
+	b := blockStats{
+		number:    number.Uint64(),
+		timestamp: timestamp.Uint64(),
+		txs:       len(block.Transactions),
+		uncles:    len(block.Uncles),
+	}
+	if b.txs == 0 {
+		b.empty = 1
+	}
+	if gasLimit.Sign() > 0 {
+		b.ratio, _ = new(big.Rat).SetFrac(gasUsed, gasLimit).Float64()
+	}
+	if baseFee, ok := apis.ParseQuantity(block.BaseFeePerGas); ok {
+		b.baseFee = baseFee
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keep := len(c.blocks)
+	for keep > 0 && c.blocks[keep-1].number >= b.number {
+		keep--
+	}
+	c.blocks = c.blocks[:keep]
+	b.totalTxs, b.totalUncles, b.totalEmpty, b.totalRatio = b.txs, b.uncles, b.empty, b.ratio
+	if keep > 0 {
+		prev := c.blocks[keep-1]
+		b.totalTxs += prev.totalTxs
+		b.totalUncles += prev.totalUncles
+		b.totalEmpty += prev.totalEmpty
+		b.totalRatio += prev.totalRatio
+	}
+	c.blocks = append(c.blocks, b)
+	drop := 0
+	for drop < len(c.blocks) && c.blocks[drop].number+uint64(c.Window) <= b.number {
+		drop++
+	}
+	if drop > 0 {
+		c.blocks = append([]blockStats(nil), c.blocks[drop:]...)
+	}
+	return nil
+}
+
+/*
+* Summary reports the statistics of the newest window blocks, or of all
+* blocks seen when there are fewer. Block time and transactions per second
+* are measured between the timestamps of the oldest and newest block, so the
+* transactions of the oldest block are not counted towards the rate.
+ */
+func (c *Chain) Summary(window int) (*apis.ChainStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.blocks) == 0 {
+		return nil, false
+	}
+	if window <= 0 || window > len(c.blocks) {
+		window = len(c.blocks)
+	}
+	oldest, newest := c.blocks[len(c.blocks)-window], c.blocks[len(c.blocks)-1]
+	summary := &apis.ChainStats{
+		BlockNumber:  apis.EncodeUint64(newest.number),
+		OldestBlock:  apis.EncodeUint64(oldest.number),
+		Blocks:       window,
+		Transactions: newest.totalTxs - oldest.totalTxs + oldest.txs,
+		GasUsedRatio: (newest.totalRatio - oldest.totalRatio + oldest.ratio) / float64(window),
+		Uncles:       newest.totalUncles - oldest.totalUncles + oldest.uncles,
+		EmptyBlocks:  newest.totalEmpty - oldest.totalEmpty + oldest.empty,
+	}
+	if newest.number > oldest.number && newest.timestamp > oldest.timestamp {
+		elapsed := float64(newest.timestamp - oldest.timestamp)
+		summary.AverageBlockTime = elapsed / float64(newest.number-oldest.number)
+		summary.TransactionsPerSecond = float64(newest.totalTxs-oldest.totalTxs) / elapsed
+	}
+	if oldest.baseFee != nil && newest.baseFee != nil {
+		summary.OldestBaseFeePerGas = apis.EncodeBig(oldest.baseFee)
+		summary.BaseFeePerGas = apis.EncodeBig(newest.baseFee)
+		summary.BaseFeeTrend = TrendFlat
+		switch newest.baseFee.Cmp(oldest.baseFee) {
+		case 1:
+			summary.BaseFeeTrend = TrendRising
+		case -1:
+			summary.BaseFeeTrend = TrendFalling
+		}
+		if oldest.baseFee.Sign() > 0 {
+			change := new(big.Rat).SetFrac(new(big.Int).Sub(newest.baseFee, oldest.baseFee), oldest.baseFee)
+			summary.BaseFeeChange, _ = change.Mul(change, big.NewRat(100, 1)).Float64()
+		}
+	}
+	return summary, true
+}
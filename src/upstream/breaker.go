@@ -0,0 +1,201 @@
+package upstream
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker open for every upstream")
+
+// BreakerConfig holds the thresholds used to trip a Breaker
+type BreakerConfig struct {
+	// FailureRate is the ratio of failed calls in the window that trips the breaker
+	FailureRate float64
+	// SlowCall is the latency above which a successful call still counts as a failure
+	SlowCall time.Duration
+	// Window is the number of most recent calls the failure rate is computed over
+	Window int
+	// MinRequests is the number of calls needed in the window before the breaker can trip
+	MinRequests int
+	// OpenTimeout is how long the breaker stays open before probing the upstream
+	OpenTimeout time.Duration
+	// HalfOpenProbes is the number of successful probes needed to close the breaker again
+	HalfOpenProbes int
+}
+
+var DefaultBreakerConfig = BreakerConfig{
+	FailureRate:    0.5,
+	SlowCall:       5 * time.Second,
+	Window:         20,
+	MinRequests:    10,
+	OpenTimeout:    30 * time.Second,
+	HalfOpenProbes: 3,
+}
+
+/*
+* Breaker is a closed/open/half-open circuit breaker over a rolling window of
+* call outcomes. Once open, calls are refused until OpenTimeout elapses, after
+* which a limited number of probe calls decide whether it closes or re-opens.
+ */
+type Breaker struct {
+	config BreakerConfig
+
+	mu        sync.Mutex
+	state     string
+	outcomes  []bool
+	next      int
+	count     int
+	failures  int
+	inFlight  int
+	successes int
+	trips     int
+	openedAt  time.Time
+}
+
+// NewBreaker returns a closed breaker, thresholds out of range fall back to DefaultBreakerConfig
+func NewBreaker(config BreakerConfig) *Breaker {
+	if !(config.FailureRate > 0 && config.FailureRate <= 1) {
+		config.FailureRate = DefaultBreakerConfig.FailureRate
+	}
+	if config.Window <= 0 {
+		config.Window = DefaultBreakerConfig.Window
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = DefaultBreakerConfig.MinRequests
+	}
+	// the window never holds more calls than its size
+	if config.MinRequests > config.Window {
+		config.MinRequests = config.Window
+	}
+	if config.HalfOpenProbes <= 0 {
+		config.HalfOpenProbes = DefaultBreakerConfig.HalfOpenProbes
+	}
+	return &Breaker{
+		config:   config,
+		state:    BreakerClosed,
+		outcomes: make([]bool, config.Window),
+	}
+}
+
+// Ready reports whether a call could be allowed without reserving a probe
+func (b *Breaker) Ready(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		return now.Sub(b.openedAt) >= b.config.OpenTimeout
+	case BreakerHalfOpen:
+		return b.inFlight < b.config.HalfOpenProbes
+	}
+	return true
+}
+
+// Allow reports whether a call may proceed, reserving a probe slot when half-open
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		if time.Since(b.openedAt) < b.config.OpenTimeout {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.inFlight = 0
+		b.successes = 0
+	}
+	if b.state == BreakerHalfOpen {
+		if b.inFlight >= b.config.HalfOpenProbes {
+			return false
+		}
+		b.inFlight++
+	}
+	return true
+}
+
+// Record feeds the outcome of an allowed call back into the breaker
+func (b *Breaker) Record(latency time.Duration, err error) {
+	failed := err != nil || (b.config.SlowCall > 0 && latency > b.config.SlowCall)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerHalfOpen:
+		if b.inFlight > 0 {
+			b.inFlight--
+		}
+		if failed {
+			b.trip()
+			return
+		}
+		if b.successes++; b.successes >= b.config.HalfOpenProbes {
+			b.reset()
+		}
+	case BreakerClosed:
+		if b.count == len(b.outcomes) && !b.outcomes[b.next] {
+			b.failures--
+		} else if b.count < len(b.outcomes) {
+			b.count++
+		}
+		b.outcomes[b.next] = !failed
+		b.next = (b.next + 1) % len(b.outcomes)
+		if failed {
+			b.failures++
+		}
+		if b.count >= b.config.MinRequests && float64(b.failures)/float64(b.count) >= b.config.FailureRate {
+			b.trip()
+		}
+	}
+}
+
+// Release frees the probe slot of an allowed call abandoned by its caller without recording an outcome
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen && b.inFlight > 0 {
+		b.inFlight--
+	}
+}
+
+// Status reports the current state of the breaker
+func (b *Breaker) Status() apis.BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := apis.BreakerStatus{
+		State:  b.state,
+		Window: b.count,
+		Trips:  b.trips,
+	}
+	if b.count > 0 {
+		status.FailureRate = float64(b.failures) / float64(b.count)
+	}
+	if b.state != BreakerClosed {
+		status.OpenedAt = b.openedAt.Format(time.RFC3339)
+		status.RetryAt = b.openedAt.Add(b.config.OpenTimeout).Format(time.RFC3339)
+	}
+	return status
+}
+
+func (b *Breaker) trip() {
+	b.state = BreakerOpen
+	b.openedAt = time.Now()
+	b.trips++
+	b.inFlight = 0
+	b.successes = 0
+}
+
+func (b *Breaker) reset() {
+	b.state = BreakerClosed
+	b.outcomes = make([]bool, len(b.outcomes))
+	b.next = 0
+	b.count = 0
+	b.failures = 0
+}
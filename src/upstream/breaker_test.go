@@ -0,0 +1,125 @@
+package upstream
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+var errCall = errors.New("call failed")
+
+func TestBreakerTransitions(t *testing.T) {
+	b := NewBreaker(BreakerConfig{FailureRate: 0.5, Window: 4, MinRequests: 4, OpenTimeout: time.Hour, HalfOpenProbes: 2})
+
+	// three failures are not enough before MinRequests calls are in the window
+	for i := 0; i < 3; i++ {
+		b.Record(time.Millisecond, errCall)
+	}
+	if state := b.Status().State; state != BreakerClosed {
+		t.Fatalf("state after 3 of 4 calls = %s, want closed", state)
+	}
+	b.Record(time.Millisecond, nil)
+	if state := b.Status().State; state != BreakerOpen {
+		t.Fatalf("state at 75%% failures = %s, want open", state)
+	}
+	if b.Allow() || b.Ready(time.Now()) {
+		t.Fatal("an open breaker allowed a call before OpenTimeout")
+	}
+	if !b.Ready(time.Now().Add(time.Hour)) {
+		t.Error("Ready() after OpenTimeout = false, want true")
+	}
+
+	// probes are admitted once the timeout elapsed, up to HalfOpenProbes at a time
+	b.config.OpenTimeout = 0
+	if !b.Allow() || !b.Allow() || b.Allow() {
+		t.Fatal("a half-open breaker must admit exactly HalfOpenProbes calls")
+	}
+	if state := b.Status().State; state != BreakerHalfOpen {
+		t.Fatalf("state while probing = %s, want half-open", state)
+	}
+	b.Record(time.Millisecond, nil)
+	if state := b.Status().State; state != BreakerHalfOpen {
+		t.Fatalf("state after 1 of 2 probes = %s, want half-open", state)
+	}
+	b.Record(time.Millisecond, nil)
+	status := b.Status()
+	if status.State != BreakerClosed || status.Window != 0 || status.Trips != 1 {
+		t.Fatalf("Status() after 2 successful probes = %+v, want closed with an empty window and 1 trip", status)
+	}
+}
+
+func TestBreakerReopensOnFailedProbe(t *testing.T) {
+	b := NewBreaker(BreakerConfig{FailureRate: 1, Window: 2, MinRequests: 2, HalfOpenProbes: 2})
+	b.Record(time.Millisecond, errCall)
+	b.Record(time.Millisecond, errCall)
+	if !b.Allow() {
+		t.Fatal("Allow() after OpenTimeout = false, want a probe")
+	}
+	b.Record(time.Millisecond, errCall)
+	if status := b.Status(); status.State != BreakerOpen || status.Trips != 2 {
+		t.Errorf("Status() after a failed probe = %+v, want open with 2 trips", status)
+	}
+}
+
+func TestBreakerSlowCalls(t *testing.T) {
+	b := NewBreaker(BreakerConfig{FailureRate: 1, SlowCall: time.Second, Window: 2, MinRequests: 2})
+	b.Record(2*time.Second, nil)
+	b.Record(2*time.Second, nil)
+	if state := b.Status().State; state != BreakerOpen {
+		t.Errorf("state after slow calls = %s, want open", state)
+	}
+}
+
+func TestBreakerWindowRolls(t *testing.T) {
+	b := NewBreaker(BreakerConfig{FailureRate: 0.5, Window: 4, MinRequests: 4})
+	for _, err := range []error{errCall, nil, nil, nil, nil, errCall, nil} {
+		b.Record(time.Millisecond, err)
+	}
+	// the first failure rolled out of the window
+	if status := b.Status(); status.State != BreakerClosed || status.Window != 4 || status.FailureRate != 0.25 {
+		t.Errorf("Status() = %+v, want closed at 25%% failures over 4 calls", status)
+	}
+}
+
+func TestBreakerRelease(t *testing.T) {
+	b := NewBreaker(BreakerConfig{FailureRate: 1, Window: 1, MinRequests: 1, HalfOpenProbes: 1})
+	b.Record(time.Millisecond, errCall)
+	if !b.Allow() || b.Allow() {
+		t.Fatal("a half-open breaker must admit exactly one probe")
+	}
+	// the caller gave up on the probe, its slot goes to the next call
+	b.Release()
+	if !b.Allow() {
+		t.Fatal("Allow() after Release() = false, want the released probe slot")
+	}
+	b.Release()
+	b.Release()
+	if !b.Allow() || b.Allow() {
+		t.Error("extra Release() calls freed more probe slots than were taken")
+	}
+
+	closed := NewBreaker(DefaultBreakerConfig)
+	closed.Release()
+	if state := closed.Status().State; state != BreakerClosed {
+		t.Errorf("Release() on a closed breaker changed its state to %s", state)
+	}
+}
+
+func TestNewBreakerClampsConfig(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		config BreakerConfig
+		want   BreakerConfig
+	}{
+		{"zero", BreakerConfig{}, BreakerConfig{FailureRate: 0.5, Window: 20, MinRequests: 10, HalfOpenProbes: 3}},
+		{"negative", BreakerConfig{FailureRate: -1, Window: -1, MinRequests: -1, HalfOpenProbes: -1}, BreakerConfig{FailureRate: 0.5, Window: 20, MinRequests: 10, HalfOpenProbes: 3}},
+		{"rate above one", BreakerConfig{FailureRate: 1.5, Window: 8, MinRequests: 4, HalfOpenProbes: 1}, BreakerConfig{FailureRate: 0.5, Window: 8, MinRequests: 4, HalfOpenProbes: 1}},
+		{"rate not a number", BreakerConfig{FailureRate: math.NaN(), Window: 8, MinRequests: 4, HalfOpenProbes: 1}, BreakerConfig{FailureRate: 0.5, Window: 8, MinRequests: 4, HalfOpenProbes: 1}},
+		{"min requests above window", BreakerConfig{FailureRate: 1, Window: 5, MinRequests: 10, HalfOpenProbes: 1}, BreakerConfig{FailureRate: 1, Window: 5, MinRequests: 5, HalfOpenProbes: 1}},
+	} {
+		if got := NewBreaker(test.config).config; got != test.want {
+			t.Errorf("%s: NewBreaker().config = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+const DefaultCoalesceTTL = time.Second
+
+// CallFunc sends a JSON-RPC request body upstream and returns the raw response
+type CallFunc func(ctx context.Context, body []byte) ([]byte, error)
+
+/*
+* Coalescer collapses identical in-flight JSON-RPC requests (same method and
+* params) into a single upstream call whose response fans out to every
+* waiter. Responses for the configured head-of-chain methods are also kept
+* for a short TTL so bursts of requests are answered without going upstream.
+ */
+type Coalescer struct {
+	TTL     time.Duration
+	Methods map[apis.RPCCall]bool
+
+	mu      sync.Mutex
+	flights map[string]*flight
+	cached  map[string]cachedResponse
+}
+
+type flight struct {
+	done chan struct{}
+	resp []byte
+	err  error
+}
+
+type cachedResponse struct {
+	resp    []byte
+	expires time.Time
+}
+
+func NewCoalescer(ttl time.Duration, methods ...apis.RPCCall) *Coalescer {
+	c := &Coalescer{
+		TTL:     ttl,
+		Methods: make(map[apis.RPCCall]bool),
+		flights: make(map[string]*flight),
+		cached:  make(map[string]cachedResponse),
+	}
+	for _, method := range methods {
+		c.Methods[method] = true
+	}
+	return c
+}
+
+/*
+* Do forwards body through call unless an identical request for the same
+* path is already in flight or cached, in which case that response is shared.
+* The id of the returned response always matches the id in body.
+ */
+func (c *Coalescer) Do(ctx context.Context, path string, body []byte, call CallFunc) ([]byte, error) {
+	var req struct {
+		ID     json.RawMessage `json:"id"`
+		Method apis.RPCCall    `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if c == nil || json.Unmarshal(body, &req) != nil {
+		return call(ctx, body)
+	}
+	key := path + "|" + string(req.Method) + "|" + string(req.Params)
+
+	c.mu.Lock()
+	if cached, ok := c.cached[key]; ok {
+		if time.Now().Before(cached.expires) {
+			c.mu.Unlock()
+			return ReplaceID(cached.resp, req.ID)
+		}
+		delete(c.cached, key)
+	}
+	f, ok := c.flights[key]
+	if !ok {
+		f = &flight{done: make(chan struct{})}
+		c.flights[key] = f
+		go c.fly(key, req.Method, body, call, f)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		if f.err != nil {
+			return nil, f.err
+		}
+		return ReplaceID(f.resp, req.ID)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fly performs the shared call detached from any single caller's context
+func (c *Coalescer) fly(key string, method apis.RPCCall, body []byte, call CallFunc, f *flight) {
+	f.resp, f.err = call(context.Background(), body)
+
+	c.mu.Lock()
+	delete(c.flights, key)
+	if f.err == nil && c.TTL > 0 && c.Methods[method] && !hasError(f.resp) {
+		c.evictExpired()
+		c.cached[key] = cachedResponse{resp: f.resp, expires: time.Now().Add(c.TTL)}
+	}
+	c.mu.Unlock()
+	close(f.done)
+}
+
+// evictExpired drops the cached responses past their TTL, c.mu must be held
+func (c *Coalescer) evictExpired() {
+	now := time.Now()
+	for key, cached := range c.cached {
+		if !now.Before(cached.expires) {
+			delete(c.cached, key)
+		}
+	}
+}
+
+// hasError reports whether a JSON-RPC response carries an error object
+func hasError(resp []byte) bool {
+	var envelope struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(resp, &envelope); err != nil {
+		return true
+	}
+	return len(envelope.Error) > 0 && string(envelope.Error) != "null"
+}
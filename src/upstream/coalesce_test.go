@@ -0,0 +1,167 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+// countingCall answers every body with a fixed response after release is closed, counting the calls
+type countingCall struct {
+	calls   int64
+	release chan struct{}
+	resp    string
+	err     error
+}
+
+func (c *countingCall) call(ctx context.Context, body []byte) ([]byte, error) {
+	atomic.AddInt64(&c.calls, 1)
+	if c.release != nil {
+		<-c.release
+	}
+	return []byte(c.resp), c.err
+}
+
+func (c *countingCall) count() int64 {
+	return atomic.LoadInt64(&c.calls)
+}
+
+func TestCoalescerSharesInFlightCalls(t *testing.T) {
+	coalescer := NewCoalescer(0)
+	upstream := &countingCall{release: make(chan struct{}), resp: `{"jsonrpc":"2.0","id":99,"result":"0x1"}`}
+
+	ids := []string{"1", `"a"`, "null", "4"}
+	responses := make([]string, len(ids))
+	var started, wg sync.WaitGroup
+	for i, id := range ids {
+		started.Add(1)
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			started.Done()
+			resp, err := coalescer.Do(context.Background(), "http", []byte(`{"jsonrpc":"2.0","id":`+id+`,"method":"eth_getBalance","params":["0x1","latest"]}`), upstream.call)
+			if err != nil {
+				t.Error(err)
+			}
+			responses[i] = string(resp)
+		}(i, id)
+	}
+	started.Wait()
+	waitFor(t, "the shared call", func() bool { return upstream.count() == 1 })
+	// give every caller time to join the flight before it lands
+	time.Sleep(50 * time.Millisecond)
+	close(upstream.release)
+	wg.Wait()
+
+	if n := upstream.count(); n != 1 {
+		t.Errorf("upstream called %d times, want 1", n)
+	}
+	for i, id := range ids {
+		if want := `{"id":` + id + `,"jsonrpc":"2.0","result":"0x1"}`; responses[i] != want {
+			t.Errorf("response %d = %s, want %s", i, responses[i], want)
+		}
+	}
+	if len(coalescer.flights) != 0 || len(coalescer.cached) != 0 {
+		t.Error("a method without TTL caching left state behind")
+	}
+}
+
+func TestCoalescerKeys(t *testing.T) {
+	coalescer := NewCoalescer(time.Minute, apis.GetBlockNumber, apis.GetGasPrice)
+	upstream := &countingCall{resp: `{"jsonrpc":"2.0","id":1,"result":"0x1"}`}
+	for _, call := range []struct{ path, body string }{
+		{"http", `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`},
+		{"http", `{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber","params":[]}`},
+		{"ws", `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`},
+		{"http", `{"jsonrpc":"2.0","id":1,"method":"eth_gasPrice","params":[]}`},
+		{"http", `{"jsonrpc":"2.0","id":1,"method":"eth_gasPrice","params":[]}`},
+		{"http", `not json`},
+		{"http", `not json`},
+	} {
+		if _, err := coalescer.Do(context.Background(), call.path, []byte(call.body), upstream.call); err != nil && call.body != "not json" {
+			t.Fatal(err)
+		}
+	}
+	// one call per path and method, bodies that are not JSON-RPC always go upstream
+	if n := upstream.count(); n != 5 {
+		t.Errorf("upstream called %d times, want 5", n)
+	}
+}
+
+func TestCoalescerTTL(t *testing.T) {
+	coalescer := NewCoalescer(50*time.Millisecond, apis.GetBlockNumber)
+	upstream := &countingCall{resp: `{"jsonrpc":"2.0","id":1,"result":"0x1"}`}
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+
+	for i := 0; i < 3; i++ {
+		coalescer.Do(context.Background(), "http", body, upstream.call)
+	}
+	if n := upstream.count(); n != 1 {
+		t.Fatalf("upstream called %d times within the TTL, want 1", n)
+	}
+	time.Sleep(60 * time.Millisecond)
+	coalescer.Do(context.Background(), "http", body, upstream.call)
+	if n := upstream.count(); n != 2 {
+		t.Errorf("upstream called %d times after the TTL, want 2", n)
+	}
+
+	// caching another key evicts the expired entries
+	time.Sleep(60 * time.Millisecond)
+	coalescer.Do(context.Background(), "ws", body, upstream.call)
+	coalescer.mu.Lock()
+	cached := len(coalescer.cached)
+	coalescer.mu.Unlock()
+	if cached != 1 {
+		t.Errorf("%d responses cached, want the expired one evicted", cached)
+	}
+}
+
+func TestCoalescerDoesNotCacheErrors(t *testing.T) {
+	coalescer := NewCoalescer(time.Minute, apis.GetBlockNumber)
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+	for _, upstream := range []*countingCall{
+		{resp: `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"header not found"}}`},
+		{err: errors.New("connection refused")},
+		{resp: `not json`},
+	} {
+		coalescer.Do(context.Background(), "http", body, upstream.call)
+		coalescer.Do(context.Background(), "http", body, upstream.call)
+		if n := upstream.count(); n != 2 {
+			t.Errorf("upstream answering %q, %v called %d times, want 2", upstream.resp, upstream.err, n)
+		}
+	}
+}
+
+func TestCoalescerCallerCancel(t *testing.T) {
+	coalescer := NewCoalescer(time.Minute, apis.GetBlockNumber)
+	upstream := &countingCall{release: make(chan struct{}), resp: `{"jsonrpc":"2.0","id":1,"result":"0x1"}`}
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := coalescer.Do(ctx, "http", body, upstream.call); err != context.Canceled {
+		t.Fatalf("Do() with a cancelled context error = %v, want %v", err, context.Canceled)
+	}
+	// the flight outlives the caller that started it and serves the next one
+	close(upstream.release)
+	if _, err := coalescer.Do(context.Background(), "http", body, upstream.call); err != nil {
+		t.Fatal(err)
+	}
+	if n := upstream.count(); n != 1 {
+		t.Errorf("upstream called %d times, want 1", n)
+	}
+}
+
+func TestNilCoalescer(t *testing.T) {
+	var coalescer *Coalescer
+	upstream := &countingCall{resp: `{"id":1}`}
+	resp, err := coalescer.Do(context.Background(), "http", []byte(`{"id":2}`), upstream.call)
+	if err != nil || string(resp) != `{"id":1}` {
+		t.Errorf("Do() on a nil Coalescer = %s, %v, want the upstream response untouched", resp, err)
+	}
+}
@@ -0,0 +1,264 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/jelias2/infra-test/src/apis"
+	"go.uber.org/zap"
+)
+
+// Upstream selection strategies
+const (
+	RoundRobin   = "round-robin"
+	LeastLatency = "least-latency"
+)
+
+const latencyDecay = 0.2
+
+var ErrNoUpstreams = errors.New("no http upstreams configured")
+
+// StatusError is returned when an upstream answers with a non 2xx status
+type StatusError struct {
+	Upstream   string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("upstream %s returned %d %s", e.Upstream, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// HttpUpstream is a single JSON-RPC provider along with its health
+type HttpUpstream struct {
+	Name   string
+	URL    string
+	Weight int
+
+	breaker             *Breaker
+	mu                  sync.Mutex
+	current             int
+	latency             time.Duration
+	requests            uint64
+	failures            uint64
+	consecutiveFailures int
+	lastError           error
+}
+
+/*
+* HttpPool spreads JSON-RPC calls across a list of upstream providers.
+* Upstreams are ordered by the configured strategy, and a call fails over to
+* the next one when an upstream errors, times out, or answers 429/5xx.
+* Every upstream sits behind its own circuit breaker, and upstreams with an
+* open breaker are skipped entirely until they are ready to be probed.
+ */
+type HttpPool struct {
+	Log       *zap.Logger
+	Resty     *resty.Client
+	Strategy  string
+	Timeout   time.Duration
+	Upstreams []*HttpUpstream
+	mu        sync.Mutex
+}
+
+func NewHttpPool(log *zap.Logger, client *resty.Client, strategy string, breaker BreakerConfig, upstreams []*HttpUpstream) *HttpPool {
+	if strategy != LeastLatency {
+		strategy = RoundRobin
+	}
+	for _, u := range upstreams {
+		u.breaker = NewBreaker(breaker)
+	}
+	return &HttpPool{
+		Log:       log,
+		Resty:     client,
+		Strategy:  strategy,
+		Timeout:   DefaultRequestTimeout,
+		Upstreams: upstreams,
+	}
+}
+
+/*
+* ParseHttpUpstreams reads a comma separated list of upstreams in the form
+* name=url|weight. The name and weight are optional, e.g.
+* "infura=https://mainnet.infura.io/v3/<id>|3,geth=http://localhost:8545"
+ */
+func ParseHttpUpstreams(spec string) ([]*HttpUpstream, error) {
+	var upstreams []*HttpUpstream
+	for i, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		u := &HttpUpstream{Name: fmt.Sprintf("upstream-%d", i), Weight: 1}
+		if eq := strings.Index(entry, "="); eq > 0 && eq < strings.Index(entry, "://") {
+			u.Name, entry = entry[:eq], entry[eq+1:]
+		}
+		if bar := strings.LastIndex(entry, "|"); bar >= 0 {
+			weight, err := strconv.Atoi(entry[bar+1:])
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid weight for upstream %s", u.Name)
+			}
+			u.Weight, entry = weight, entry[:bar]
+		}
+		if entry == "" {
+			return nil, fmt.Errorf("missing url for upstream %s", u.Name)
+		}
+		u.URL = entry
+		upstreams = append(upstreams, u)
+	}
+	return upstreams, nil
+}
+
+/*
+* Post sends body to the upstreams in selection order until one of them
+* answers successfully. result is unmarshalled from the successful response
+* when it is not nil. The response of the last attempt is always returned, and
+* ErrCircuitOpen is returned when every breaker refused the call.
+ */
+func (p *HttpPool) Post(ctx context.Context, body interface{}, result interface{}) (*resty.Response, error) {
+	var resp *resty.Response
+	err := ErrNoUpstreams
+	if len(p.Upstreams) > 0 {
+		err = ErrCircuitOpen
+	}
+	for _, u := range p.candidates() {
+		if !u.breaker.Allow() {
+			continue
+		}
+		resp, err = p.attempt(ctx, u, body, result)
+		if err == nil {
+			return resp, nil
+		}
+		p.Log.Error("Upstream request failed", zap.String("Upstream", u.Name), zap.Error(err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return resp, err
+}
+
+func (p *HttpPool) attempt(ctx context.Context, u *HttpUpstream, body interface{}, result interface{}) (*resty.Response, error) {
+	attemptCtx := ctx
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	resp, err := p.Resty.R().SetContext(attemptCtx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body).
+		Post(u.URL)
+	if err == nil && (resp.StatusCode() < http.StatusOK || resp.StatusCode() >= http.StatusMultipleChoices) {
+		err = &StatusError{Upstream: u.Name, StatusCode: resp.StatusCode()}
+	}
+	latency := time.Since(start)
+	u.record(latency, err)
+	if ctx.Err() == nil {
+		u.breaker.Record(latency, err)
+	} else {
+		u.breaker.Release()
+	}
+	if err == nil && result != nil {
+		err = json.Unmarshal(resp.Body(), result)
+	}
+	return resp, err
+}
+
+// candidates orders the upstreams whose breaker is ready by strategy
+func (p *HttpPool) candidates() []*HttpUpstream {
+	var healthy []*HttpUpstream
+	now := time.Now()
+	for _, u := range p.Upstreams {
+		if u.breaker.Ready(now) {
+			healthy = append(healthy, u)
+		}
+	}
+
+	switch p.Strategy {
+	case LeastLatency:
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].averageLatency() < healthy[j].averageLatency()
+		})
+	default:
+		if first := p.nextWeighted(healthy); first > 0 {
+			healthy[0], healthy[first] = healthy[first], healthy[0]
+		}
+	}
+	return healthy
+}
+
+// nextWeighted picks an index from upstreams with smooth weighted round robin
+func (p *HttpPool) nextWeighted(upstreams []*HttpUpstream) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	best, total := -1, 0
+	for i, u := range upstreams {
+		u.current += u.Weight
+		total += u.Weight
+		if best < 0 || u.current > upstreams[best].current {
+			best = i
+		}
+	}
+	if best >= 0 {
+		upstreams[best].current -= total
+	}
+	return best
+}
+
+// Status reports the health of every upstream
+func (p *HttpPool) Status() []apis.HttpUpstreamStatus {
+	statuses := make([]apis.HttpUpstreamStatus, 0, len(p.Upstreams))
+	for _, u := range p.Upstreams {
+		breaker := u.breaker.Status()
+		u.mu.Lock()
+		status := apis.HttpUpstreamStatus{
+			Name:                u.Name,
+			Weight:              u.Weight,
+			Healthy:             breaker.State == BreakerClosed,
+			Breaker:             breaker,
+			LatencyMs:           float64(u.latency) / float64(time.Millisecond),
+			Requests:            u.requests,
+			Failures:            u.failures,
+			ConsecutiveFailures: u.consecutiveFailures,
+		}
+		if u.lastError != nil {
+			status.LastError = u.lastError.Error()
+		}
+		u.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func (u *HttpUpstream) record(latency time.Duration, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.requests++
+	if u.latency == 0 {
+		u.latency = latency
+	} else {
+		u.latency += time.Duration(latencyDecay * float64(latency-u.latency))
+	}
+	if err == nil {
+		u.consecutiveFailures = 0
+		return
+	}
+	u.failures++
+	u.consecutiveFailures++
+	u.lastError = err
+}
+
+func (u *HttpUpstream) averageLatency() time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.latency
+}
@@ -0,0 +1,248 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/jelias2/infra-test/src/apis"
+	"go.uber.org/zap"
+)
+
+const DefaultHubClientBuffer = 64
+
+// Policies applied by the Hub to a client whose buffer is full
+const (
+	SlowClientDrop       = "drop"
+	SlowClientDisconnect = "disconnect"
+)
+
+var ErrSlowConsumer = errors.New("subscriber could not keep up with notifications")
+
+/*
+* Hub shares upstream subscriptions between local subscribers. Subscribers
+* asking for the same subscription type and filter are served by a single
+* upstream eth_subscribe whose notifications fan out to each of them.
+* Every subscriber has its own bounded buffer and fan out never blocks: when
+* a buffer is full the notification is dropped for that subscriber, or with
+* the disconnect policy the subscriber is closed with ErrSlowConsumer.
+ */
+type Hub struct {
+	Log    *zap.Logger
+	Buffer int
+	Policy string
+	pool   *WsPool
+
+	mu     sync.Mutex
+	topics map[string]*topic
+}
+
+// topic is a single upstream subscription and the local subscribers sharing it
+type topic struct {
+	key     string
+	params  json.RawMessage
+	ready   chan struct{}
+	err     error
+	sub     *Subscription
+	waiting int
+	clients map[*HubSubscription]bool
+}
+
+// HubSubscription is a local subscriber of a Hub topic, notifications are delivered on C
+type HubSubscription struct {
+	ID    string
+	C     <-chan json.RawMessage
+	hub   *Hub
+	topic *topic
+
+	c       chan json.RawMessage
+	closed  bool
+	err     error
+	dropped uint64
+}
+
+func NewHub(log *zap.Logger, pool *WsPool, buffer int, policy string) *Hub {
+	if buffer <= 0 {
+		buffer = DefaultHubClientBuffer
+	}
+	if policy != SlowClientDisconnect {
+		policy = SlowClientDrop
+	}
+	return &Hub{
+		Log:    log,
+		Buffer: buffer,
+		Policy: policy,
+		pool:   pool,
+		topics: make(map[string]*topic),
+	}
+}
+
+/*
+* Subscribe joins the topic for params, creating the upstream subscription
+* when it is the first subscriber. Concurrent first subscribers wait for the
+* same upstream eth_subscribe rather than each sending their own.
+ */
+func (hub *Hub) Subscribe(ctx context.Context, params json.RawMessage) (*HubSubscription, error) {
+	key, err := topicKey(params)
+	if err != nil {
+		return nil, &apis.RPCError{Code: -32602, Message: "Invalid subscription params: " + err.Error()}
+	}
+	c := make(chan json.RawMessage, hub.Buffer)
+	s := &HubSubscription{ID: NewSubscriptionID(), C: c, c: c, hub: hub}
+
+	hub.mu.Lock()
+	t, ok := hub.topics[key]
+	if !ok {
+		t = &topic{key: key, params: params, ready: make(chan struct{}), clients: make(map[*HubSubscription]bool)}
+		hub.topics[key] = t
+		go hub.open(t)
+	}
+	t.waiting++
+	hub.mu.Unlock()
+
+	select {
+	case <-t.ready:
+	case <-ctx.Done():
+		hub.mu.Lock()
+		t.waiting--
+		orphan := hub.orphaned(t)
+		hub.mu.Unlock()
+		if orphan != nil {
+			orphan.Unsubscribe()
+		}
+		return nil, ctx.Err()
+	}
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	hub.mu.Lock()
+	t.waiting--
+	s.topic = t
+	t.clients[s] = true
+	hub.mu.Unlock()
+	return s, nil
+}
+
+// open creates the upstream subscription of t and starts fanning out its notifications
+func (hub *Hub) open(t *topic) {
+	sub, err := hub.pool.Subscribe(context.Background(), t.params, 0)
+	hub.mu.Lock()
+	if err != nil {
+		delete(hub.topics, t.key)
+	}
+	t.sub, t.err = sub, err
+	close(t.ready)
+	orphan := hub.orphaned(t)
+	hub.mu.Unlock()
+	if err != nil {
+		return
+	}
+	if orphan != nil {
+		// every subscriber gave up before the upstream answered
+		orphan.Unsubscribe()
+		return
+	}
+	hub.Log.Info("Opened shared upstream subscription", zap.String("Params", t.key))
+	go hub.fanOut(t)
+}
+
+func (hub *Hub) fanOut(t *topic) {
+	for result := range t.sub.C {
+		hub.mu.Lock()
+		for s := range t.clients {
+			select {
+			case s.c <- result:
+			default:
+				s.dropped++
+				if hub.Policy == SlowClientDisconnect {
+					hub.Log.Info("Disconnecting slow subscriber", zap.String("Subscription", s.ID))
+					hub.remove(s, ErrSlowConsumer)
+				}
+			}
+		}
+		hub.mu.Unlock()
+	}
+}
+
+// Unsubscribe leaves the topic and closes C, the upstream subscription is cancelled with its last subscriber
+func (s *HubSubscription) Unsubscribe() error {
+	s.hub.mu.Lock()
+	sub := s.hub.remove(s, nil)
+	s.hub.mu.Unlock()
+	if sub != nil {
+		return sub.Unsubscribe()
+	}
+	return nil
+}
+
+// Err reports why C was closed, ErrSlowConsumer when the subscriber fell too far behind
+func (s *HubSubscription) Err() error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.err
+}
+
+// remove closes s and returns the upstream subscription to cancel when s was its last subscriber, hub.mu must be held
+func (hub *Hub) remove(s *HubSubscription, err error) *Subscription {
+	if s.closed {
+		return nil
+	}
+	s.closed, s.err = true, err
+	close(s.c)
+	t := s.topic
+	delete(t.clients, s)
+	orphan := hub.orphaned(t)
+	if orphan != nil && err != nil {
+		// called from fanOut, which must not wait on the upstream
+		go orphan.Unsubscribe()
+		return nil
+	}
+	return orphan
+}
+
+// orphaned removes an opened topic nobody subscribes to or waits for and returns its upstream subscription, hub.mu must be held
+func (hub *Hub) orphaned(t *topic) *Subscription {
+	if t.sub == nil || t.waiting > 0 || len(t.clients) > 0 || hub.topics[t.key] != t {
+		return nil
+	}
+	delete(hub.topics, t.key)
+	return t.sub
+}
+
+// Status reports the subscribers and dropped notifications of every shared subscription
+func (hub *Hub) Status() []apis.HubTopicStatus {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	statuses := make([]apis.HubTopicStatus, 0, len(hub.topics))
+	for _, t := range hub.topics {
+		status := apis.HubTopicStatus{Params: json.RawMessage(t.key), Subscribers: len(t.clients)}
+		if t.sub != nil {
+			status.Subscription = t.sub.ID
+			status.Dropped = t.sub.Dropped()
+		}
+		for s := range t.clients {
+			status.Dropped += s.dropped
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return string(statuses[i].Params) < string(statuses[j].Params)
+	})
+	return statuses
+}
+
+// topicKey normalises subscription params so equal filters share a topic regardless of key order or spacing
+func topicKey(params json.RawMessage) (string, error) {
+	var v []interface{}
+	if err := json.Unmarshal(params, &v); err != nil {
+		return "", err
+	}
+	if len(v) == 0 {
+		return "", errors.New("missing subscription type")
+	}
+	key, err := json.Marshal(v)
+	return string(key), err
+}
@@ -0,0 +1,171 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jelias2/infra-test/src/apis"
+	"go.uber.org/zap"
+)
+
+func TestTopicKey(t *testing.T) {
+	for _, test := range []struct {
+		params, want string
+	}{
+		{`["newHeads"]`, `["newHeads"]`},
+		{` [ "logs" , {"topics":[],"address":"0x1"} ] `, `["logs",{"address":"0x1","topics":[]}]`},
+		{`["logs",{"address":"0x1","topics":[]}]`, `["logs",{"address":"0x1","topics":[]}]`},
+	} {
+		if got, err := topicKey(json.RawMessage(test.params)); err != nil || got != test.want {
+			t.Errorf("topicKey(%s) = %s, %v, want %s", test.params, got, err, test.want)
+		}
+	}
+	for _, invalid := range []string{`[]`, `{"type":"newHeads"}`, `newHeads`} {
+		if _, err := topicKey(json.RawMessage(invalid)); err == nil {
+			t.Errorf("topicKey(%s) succeeded, want an error", invalid)
+		}
+	}
+}
+
+func newTestHub(t *testing.T, buffer int, policy string) (*Hub, *fakeNode) {
+	node := newFakeNode(t)
+	return NewHub(zap.NewNop(), newTestPool(t, node, 1), buffer, policy), node
+}
+
+func hubSubscribe(t *testing.T, hub *Hub, params string) *HubSubscription {
+	t.Helper()
+	s, err := hub.Subscribe(context.Background(), json.RawMessage(params))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestHubRefcountsTopics(t *testing.T) {
+	hub, node := newTestHub(t, 0, "")
+	first := hubSubscribe(t, hub, `["logs",{"address":"0x1","topics":[]}]`)
+	second := hubSubscribe(t, hub, `["logs",{"topics":[],"address":"0x1"}]`)
+	other := hubSubscribe(t, hub, `["newHeads"]`)
+	if n := node.count(apis.Subscribe); n != 2 {
+		t.Fatalf("eth_subscribe sent %d times, want 1 per distinct topic", n)
+	}
+	status := hub.Status()
+	if len(status) != 2 || status[0].Subscribers != 2 || status[1].Subscribers != 1 {
+		t.Errorf("Status() = %+v, want 2 subscribers of the logs topic and 1 of newHeads", status)
+	}
+
+	node.notify("0xa")
+	for _, s := range []*HubSubscription{first, second, other} {
+		if got := receive(t, s.C); got != "0xa" {
+			t.Errorf("subscriber got %s, want 0xa", got)
+		}
+	}
+
+	first.Unsubscribe()
+	if _, ok := <-first.C; ok {
+		t.Error("Unsubscribe() did not close C")
+	}
+	if n := node.count(apis.Unsubscribe); n != 0 {
+		t.Errorf("eth_unsubscribe sent while the topic has a subscriber")
+	}
+	second.Unsubscribe()
+	if n := node.count(apis.Unsubscribe); n != 1 {
+		t.Errorf("eth_unsubscribe sent %d times after the last subscriber left, want 1", n)
+	}
+	if status := hub.Status(); len(status) != 1 {
+		t.Errorf("Status() = %+v, want only the newHeads topic", status)
+	}
+
+	// a new subscriber of a closed topic opens it again
+	third := hubSubscribe(t, hub, `["logs",{"address":"0x1","topics":[]}]`)
+	defer third.Unsubscribe()
+	if n := node.count(apis.Subscribe); n != 3 {
+		t.Errorf("eth_subscribe sent %d times, want the topic reopened", n)
+	}
+	other.Unsubscribe()
+	other.Unsubscribe()
+	if n := node.count(apis.Unsubscribe); n != 2 {
+		t.Errorf("eth_unsubscribe sent %d times, want repeated Unsubscribe() to be a no-op", n)
+	}
+}
+
+func TestHubSubscriberGivesUpBeforeUpstreamAnswers(t *testing.T) {
+	hub, node := newTestHub(t, 0, "")
+	node.hold(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := hub.Subscribe(ctx, newHeads); err != context.DeadlineExceeded {
+		t.Fatalf("Subscribe() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	node.hold(false)
+	node.answer(<-node.requests)
+	// the upstream subscription opened for nobody is cancelled again
+	waitFor(t, "the orphaned subscription to be cancelled", func() bool {
+		return node.count(apis.Unsubscribe) == 1 && node.liveSubscriptions() == 0
+	})
+	if status := hub.Status(); len(status) != 0 {
+		t.Errorf("Status() = %+v, want no topics", status)
+	}
+}
+
+func TestHubDropsForSlowClients(t *testing.T) {
+	hub, node := newTestHub(t, 1, SlowClientDrop)
+	fast := hubSubscribe(t, hub, `["newHeads"]`)
+	slow := hubSubscribe(t, hub, `["newHeads"]`)
+	defer fast.Unsubscribe()
+	defer slow.Unsubscribe()
+
+	for _, head := range []string{"0x1", "0x2", "0x3"} {
+		node.notify(head)
+		if got := receive(t, fast.C); got != head {
+			t.Errorf("fast subscriber got %s, want %s", got, head)
+		}
+	}
+	if got := receive(t, slow.C); got != "0x1" {
+		t.Errorf("slow subscriber got %s, want the first notification", got)
+	}
+	if slow.Err() != nil {
+		t.Errorf("slow subscriber closed with %v under the drop policy", slow.Err())
+	}
+	if status := hub.Status(); len(status) != 1 || status[0].Dropped != 2 {
+		t.Errorf("Status() = %+v, want 2 dropped notifications", status)
+	}
+}
+
+func TestHubDisconnectsSlowClients(t *testing.T) {
+	hub, node := newTestHub(t, 1, SlowClientDisconnect)
+	fast := hubSubscribe(t, hub, `["newHeads"]`)
+	slow := hubSubscribe(t, hub, `["newHeads"]`)
+	defer fast.Unsubscribe()
+
+	for _, head := range []string{"0x1", "0x2"} {
+		node.notify(head)
+		receive(t, fast.C)
+	}
+	if got := receive(t, slow.C); got != "0x1" {
+		t.Errorf("slow subscriber got %s, want the first notification", got)
+	}
+	if _, ok := <-slow.C; ok || slow.Err() != ErrSlowConsumer {
+		t.Errorf("slow subscriber Err() = %v, want C closed with %v", slow.Err(), ErrSlowConsumer)
+	}
+	if status := hub.Status(); len(status) != 1 || status[0].Subscribers != 1 {
+		t.Errorf("Status() = %+v, want the fast subscriber left", status)
+	}
+
+	// the last subscriber being disconnected cancels the upstream subscription
+	node.notify("0x3")
+	node.notify("0x4")
+	waitFor(t, "the upstream subscription to be cancelled", func() bool { return node.count(apis.Unsubscribe) == 1 })
+	if fast.Err() != ErrSlowConsumer {
+		t.Errorf("fast subscriber Err() = %v, want %v", fast.Err(), ErrSlowConsumer)
+	}
+}
+
+func TestNewHubDefaults(t *testing.T) {
+	hub := NewHub(zap.NewNop(), nil, 0, "bogus")
+	if hub.Buffer != DefaultHubClientBuffer || hub.Policy != SlowClientDrop {
+		t.Errorf("NewHub() = buffer %d policy %s, want %d and %s", hub.Buffer, hub.Policy, DefaultHubClientBuffer, SlowClientDrop)
+	}
+}
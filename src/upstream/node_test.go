@@ -0,0 +1,218 @@
+package upstream
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jelias2/infra-test/src/apis"
+	"go.uber.org/zap"
+)
+
+// nodeRequest is a JSON-RPC request received by a fakeNode
+type nodeRequest struct {
+	conn   *nodeConn
+	ID     json.RawMessage `json:"id"`
+	Method apis.RPCCall    `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type nodeConn struct {
+	ws *websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *nodeConn) send(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteJSON(v)
+}
+
+/*
+* fakeNode is a websocket JSON-RPC upstream. It answers eth_subscribe with
+* a new subscription id, eth_unsubscribe with true and any other method with
+* its params. When held, requests are queued on requests for the test to
+* answer instead, the first refuse dials are answered 503.
+ */
+type fakeNode struct {
+	server   *httptest.Server
+	requests chan nodeRequest
+
+	mu      sync.Mutex
+	conns   []*nodeConn
+	dials   int
+	refuse  int
+	held    bool
+	subs    map[string]*nodeConn
+	nextSub int
+	calls   map[apis.RPCCall]int
+}
+
+func newFakeNode(t *testing.T) *fakeNode {
+	t.Helper()
+	n := &fakeNode{requests: make(chan nodeRequest, 64), subs: make(map[string]*nodeConn), calls: make(map[apis.RPCCall]int)}
+	upgrader := websocket.Upgrader{}
+	n.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.mu.Lock()
+		n.dials++
+		refuse := n.refuse > 0
+		if refuse {
+			n.refuse--
+		}
+		n.mu.Unlock()
+		if refuse {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c := &nodeConn{ws: ws}
+		n.mu.Lock()
+		n.conns = append(n.conns, c)
+		n.mu.Unlock()
+		n.serve(c)
+	}))
+	t.Cleanup(func() {
+		n.drop()
+		n.server.Close()
+	})
+	return n
+}
+
+// url is the websocket endpoint of the node
+func (n *fakeNode) url() string {
+	return "ws" + strings.TrimPrefix(n.server.URL, "http")
+}
+
+func (n *fakeNode) serve(c *nodeConn) {
+	defer c.ws.Close()
+	for {
+		_, msg, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		req := nodeRequest{conn: c}
+		if json.Unmarshal(msg, &req) != nil {
+			continue
+		}
+		n.mu.Lock()
+		n.calls[req.Method]++
+		held := n.held
+		n.mu.Unlock()
+		if held {
+			n.requests <- req
+			continue
+		}
+		n.answer(req)
+	}
+}
+
+// answer replies to req as an unheld node does
+func (n *fakeNode) answer(req nodeRequest) {
+	var result interface{} = req.Params
+	switch req.Method {
+	case apis.Subscribe:
+		n.mu.Lock()
+		n.nextSub++
+		wireID := "0x" + strconv.Itoa(n.nextSub)
+		n.subs[wireID] = req.conn
+		n.mu.Unlock()
+		result = wireID
+	case apis.Unsubscribe:
+		var ids []string
+		json.Unmarshal(req.Params, &ids)
+		n.mu.Lock()
+		for _, id := range ids {
+			delete(n.subs, id)
+		}
+		n.mu.Unlock()
+		result = true
+	}
+	req.conn.send(map[string]interface{}{"jsonrpc": apis.RPCVersion2, "id": req.ID, "result": result})
+}
+
+func (n *fakeNode) hold(held bool) {
+	n.mu.Lock()
+	n.held = held
+	n.mu.Unlock()
+}
+
+// notify sends result to every live upstream subscription
+func (n *fakeNode) notify(result string) {
+	n.mu.Lock()
+	subs := make(map[string]*nodeConn, len(n.subs))
+	for wireID, c := range n.subs {
+		subs[wireID] = c
+	}
+	n.mu.Unlock()
+	for wireID, c := range subs {
+		c.send(map[string]interface{}{
+			"jsonrpc": apis.RPCVersion2,
+			"method":  apis.SubscriptionNotification,
+			"params":  map[string]interface{}{"subscription": wireID, "result": result},
+		})
+	}
+}
+
+// drop closes every connection, forgetting the subscriptions made on them
+func (n *fakeNode) drop() {
+	n.mu.Lock()
+	conns := n.conns
+	n.conns = nil
+	n.subs = make(map[string]*nodeConn)
+	n.mu.Unlock()
+	for _, c := range conns {
+		c.ws.Close()
+	}
+}
+
+func (n *fakeNode) count(method apis.RPCCall) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.calls[method]
+}
+
+func (n *fakeNode) liveSubscriptions() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.subs)
+}
+
+// newTestPool returns a pool of size connections to n once all of them are connected
+func newTestPool(t *testing.T, n *fakeNode, size int) *WsPool {
+	t.Helper()
+	p := NewWsPool(zap.NewNop(), n.url(), size)
+	t.Cleanup(p.Close)
+	waitFor(t, "the pool to connect", func() bool { return connected(p) == size })
+	return p
+}
+
+func connected(p *WsPool) int {
+	n := 0
+	for _, status := range p.Status() {
+		if status.State == StateConnected {
+			n++
+		}
+	}
+	return n
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
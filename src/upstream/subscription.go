@@ -0,0 +1,188 @@
+package upstream
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/jelias2/infra-test/src/apis"
+	"go.uber.org/zap"
+)
+
+const DefaultSubscriptionBuffer = 256
+
+/*
+* Subscription is an upstream eth_subscribe kept alive by the pool. The
+* upstream subscription id changes every time the pool has to resubscribe
+* after a reconnect, ID stays the same for the lifetime of the subscription.
+* Notification results are delivered on C, which is closed by Unsubscribe.
+* When C is full further notifications are dropped rather than stalling the
+* connection they arrived on.
+ */
+type Subscription struct {
+	ID     string
+	C      <-chan json.RawMessage
+	pool   *WsPool
+	params json.RawMessage
+
+	mu      sync.Mutex
+	c       chan json.RawMessage
+	conn    *wsConn
+	wireID  string
+	closed  bool
+	dropped uint64
+}
+
+/*
+* Subscribe sends eth_subscribe with params upstream and returns the
+* subscription once the upstream accepted it. An upstream JSON-RPC error is
+* returned as an *apis.RPCError.
+ */
+func (p *WsPool) Subscribe(ctx context.Context, params json.RawMessage, buffer int) (*Subscription, error) {
+	if buffer <= 0 {
+		buffer = DefaultSubscriptionBuffer
+	}
+	c := make(chan json.RawMessage, buffer)
+	sub := &Subscription{ID: NewSubscriptionID(), C: c, c: c, pool: p, params: params}
+	if err := p.subscribe(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// subscribe sends eth_subscribe for sub, which is registered on its connection by the read loop
+func (p *WsPool) subscribe(ctx context.Context, sub *Subscription) error {
+	body, err := json.Marshal(apis.RPCRequest{
+		JsonRPC: apis.RPCVersion2,
+		Method:  apis.Subscribe,
+		Params:  sub.params,
+		ID:      json.RawMessage("1"),
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := p.call(ctx, body, sub)
+	if err != nil {
+		return err
+	}
+	var result apis.RPCResponse
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return err
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// resubscribe recreates sub upstream after its connection was lost, retrying with backoff
+func (p *WsPool) resubscribe(sub *Subscription) {
+	backoff := p.MinBackoff
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-time.After(jitter(backoff)):
+		}
+		if sub.isClosed() {
+			return
+		}
+		err := p.subscribe(context.Background(), sub)
+		if err == nil {
+			p.Log.Info("Resubscribed upstream subscription", zap.String("Subscription", sub.ID))
+			if sub.isClosed() {
+				sub.unsubscribe()
+			}
+			return
+		}
+		p.Log.Error("Error resubscribing upstream subscription", zap.String("Subscription", sub.ID), zap.Error(err))
+		backoff = p.nextBackoff(backoff)
+	}
+}
+
+// Unsubscribe closes C and cancels the subscription upstream
+func (s *Subscription) Unsubscribe() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.c)
+	s.mu.Unlock()
+	return s.unsubscribe()
+}
+
+// unsubscribe sends eth_unsubscribe on the connection currently holding the subscription
+func (s *Subscription) unsubscribe() error {
+	s.mu.Lock()
+	c, wireID := s.conn, s.wireID
+	s.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	delete(c.subs, wireID)
+	c.mu.Unlock()
+
+	body, err := json.Marshal(apis.RPCRequest{
+		JsonRPC: apis.RPCVersion2,
+		Method:  apis.Unsubscribe,
+		Params:  json.RawMessage(`["` + wireID + `"]`),
+		ID:      json.RawMessage("1"),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.call(context.Background(), body)
+	return err
+}
+
+// Dropped is the number of notifications discarded because C was full
+func (s *Subscription) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+func (s *Subscription) deliver(result json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.c <- result:
+	default:
+		s.dropped++
+		s.pool.Log.Info("Dropping notification for slow subscription", zap.String("Subscription", s.ID))
+	}
+}
+
+func (s *Subscription) attach(c *wsConn, wireID string) {
+	s.mu.Lock()
+	s.conn, s.wireID = c, wireID
+	s.mu.Unlock()
+}
+
+func (s *Subscription) detach() {
+	s.mu.Lock()
+	s.conn, s.wireID = nil, ""
+	s.mu.Unlock()
+}
+
+func (s *Subscription) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// NewSubscriptionID returns a random hex subscription id in the format used by ethereum nodes
+func NewSubscriptionID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return "0x" + hex.EncodeToString(id)
+}
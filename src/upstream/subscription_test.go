@@ -0,0 +1,136 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+var newHeads = json.RawMessage(`["newHeads"]`)
+
+// receive waits for the next notification on c
+func receive(t *testing.T, c <-chan json.RawMessage) string {
+	t.Helper()
+	select {
+	case result, ok := <-c:
+		if !ok {
+			t.Fatal("subscription channel closed")
+		}
+		var s string
+		json.Unmarshal(result, &s)
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a notification")
+	}
+	return ""
+}
+
+func TestSubscriptionResubscribesAfterReconnect(t *testing.T) {
+	node := newFakeNode(t)
+	pool := newTestPool(t, node, 1)
+	sub, err := pool.Subscribe(context.Background(), newHeads, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	node.notify("0xa")
+	if got := receive(t, sub.C); got != "0xa" {
+		t.Errorf("notification = %s, want 0xa", got)
+	}
+
+	id := sub.ID
+	node.drop()
+	waitFor(t, "the subscription to be recreated", func() bool {
+		return node.count(apis.Subscribe) == 2 && node.liveSubscriptions() == 1
+	})
+	// the recreated upstream subscription has a new wire id but feeds the same channel
+	node.notify("0xb")
+	if got := receive(t, sub.C); got != "0xb" {
+		t.Errorf("notification after reconnecting = %s, want 0xb", got)
+	}
+	if sub.ID != id {
+		t.Errorf("subscription id changed from %s to %s", id, sub.ID)
+	}
+	if subs := pool.Status()[0].Subscriptions; subs != 1 {
+		t.Errorf("connection holds %d subscriptions, want 1", subs)
+	}
+}
+
+func TestSubscriptionUnsubscribe(t *testing.T) {
+	node := newFakeNode(t)
+	pool := newTestPool(t, node, 1)
+	sub, err := pool.Subscribe(context.Background(), newHeads, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-sub.C; ok {
+		t.Error("Unsubscribe() did not close C")
+	}
+	if n := node.liveSubscriptions(); n != 0 || node.count(apis.Unsubscribe) != 1 {
+		t.Errorf("node has %d live subscriptions after Unsubscribe(), want 0", n)
+	}
+	if err := sub.Unsubscribe(); err != nil || node.count(apis.Unsubscribe) != 1 {
+		t.Errorf("second Unsubscribe() = %v, want a no-op", err)
+	}
+	if subs := pool.Status()[0].Subscriptions; subs != 0 {
+		t.Errorf("connection holds %d subscriptions, want 0", subs)
+	}
+}
+
+func TestSubscriptionClosedWhileDisconnected(t *testing.T) {
+	node := newFakeNode(t)
+	pool := newTestPool(t, node, 1)
+	sub, err := pool.Subscribe(context.Background(), newHeads, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.drop()
+	waitFor(t, "the subscription to detach", func() bool { return pool.Status()[0].Subscriptions == 0 })
+	sub.Unsubscribe()
+	// the resubscribe loop waits at least half of MinBackoff before noticing
+	time.Sleep(DefaultMinBackoff + 100*time.Millisecond)
+	if n := node.count(apis.Subscribe); n != 1 {
+		t.Errorf("eth_subscribe sent %d times, want no resubscribe of a closed subscription", n)
+	}
+}
+
+func TestSubscriptionDropsWhenFull(t *testing.T) {
+	node := newFakeNode(t)
+	pool := newTestPool(t, node, 1)
+	sub, err := pool.Subscribe(context.Background(), newHeads, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	for _, head := range []string{"0x1", "0x2", "0x3"} {
+		node.notify(head)
+	}
+	waitFor(t, "notifications to be dropped", func() bool { return sub.Dropped() == 2 })
+	if got := receive(t, sub.C); got != "0x1" {
+		t.Errorf("buffered notification = %s, want the first one", got)
+	}
+}
+
+func TestSubscribeError(t *testing.T) {
+	node := newFakeNode(t)
+	pool := newTestPool(t, node, 1)
+	node.hold(true)
+	go func() {
+		req := <-node.requests
+		req.conn.send(map[string]interface{}{"jsonrpc": apis.RPCVersion2, "id": req.ID, "error": apis.RPCError{Code: -32602, Message: "invalid subscription"}})
+	}()
+	_, err := pool.Subscribe(context.Background(), json.RawMessage(`["bogus"]`), 0)
+	rpcErr, ok := err.(*apis.RPCError)
+	if !ok || rpcErr.Code != -32602 {
+		t.Errorf("Subscribe() error = %v, want the upstream RPC error", err)
+	}
+	if subs := pool.Status()[0].Subscriptions; subs != 0 {
+		t.Errorf("a refused subscription was registered")
+	}
+}
@@ -0,0 +1,83 @@
+package upstream
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestJitter(t *testing.T) {
+	for _, d := range []time.Duration{time.Nanosecond * 2, time.Millisecond, DefaultMinBackoff, DefaultMaxBackoff} {
+		for i := 0; i < 100; i++ {
+			if got := jitter(d); got < d/2 || got > d {
+				t.Fatalf("jitter(%s) = %s, want between %s and %s", d, got, d/2, d)
+			}
+		}
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	p := &WsPool{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	var got []time.Duration
+	for backoff := p.MinBackoff; len(got) < 5; {
+		backoff = p.nextBackoff(backoff)
+		got = append(got, backoff)
+	}
+	want := []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("backoff sequence = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSuperviseRetriesFailedDials(t *testing.T) {
+	node := newFakeNode(t)
+	node.mu.Lock()
+	node.refuse = 2
+	node.mu.Unlock()
+	pool := NewWsPool(zap.NewNop(), node.url(), 1)
+	defer pool.Close()
+
+	waitFor(t, "the first dial to fail", func() bool { return pool.Status()[0].State == StateReconnecting })
+	if status := pool.Status()[0]; !strings.Contains(status.LastError, "bad handshake") {
+		t.Errorf("Status() after a refused dial = %+v, want the handshake error", status)
+	}
+	waitFor(t, "the pool to connect", func() bool { return connected(pool) == 1 })
+	node.mu.Lock()
+	dials := node.dials
+	node.mu.Unlock()
+	if dials != 3 {
+		t.Errorf("dialed %d times, want 2 refused dials and 1 accepted", dials)
+	}
+	// failed dials are not reconnects of an established connection
+	if reconnects := pool.Status()[0].Reconnects; reconnects != 0 {
+		t.Errorf("Reconnects = %d, want 0", reconnects)
+	}
+}
+
+func TestSuperviseRedialsDroppedConnections(t *testing.T) {
+	node := newFakeNode(t)
+	pool := newTestPool(t, node, 2)
+
+	for drops := 1; drops <= 2; drops++ {
+		node.drop()
+		waitFor(t, "the pool to reconnect", func() bool {
+			reconnected := 0
+			for _, status := range pool.Status() {
+				if status.State == StateConnected && status.Reconnects == drops {
+					reconnected++
+				}
+			}
+			return reconnected == 2
+		})
+	}
+	node.mu.Lock()
+	dials := node.dials
+	node.mu.Unlock()
+	if dials != 6 {
+		t.Errorf("dialed %d times, want 2 connections dialed 3 times each", dials)
+	}
+}
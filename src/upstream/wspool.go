@@ -0,0 +1,437 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jelias2/infra-test/src/apis"
+	"go.uber.org/zap"
+)
+
+const DefaultWsPoolSize = 4
+const DefaultRequestTimeout = 10 * time.Second
+const DefaultMinBackoff = 500 * time.Millisecond
+const DefaultMaxBackoff = 30 * time.Second
+const writeWait = 5 * time.Second
+const pongWait = 60 * time.Second
+const pingPeriod = (pongWait * 9) / 10
+
+// Connection states reported through Status
+const (
+	StateConnecting   = "connecting"
+	StateConnected    = "connected"
+	StateReconnecting = "reconnecting"
+	StateClosed       = "closed"
+)
+
+var ErrConnClosed = errors.New("upstream websocket connection closed")
+var ErrNoConnection = errors.New("no upstream websocket connection available")
+
+/*
+* WsPool multiplexes JSON-RPC requests over a bounded set of upstream
+* websocket connections. Every request is given a pool wide unique id on the
+* wire, and the response is matched back to its caller by that id, so any
+* number of in-flight requests can safely share a connection.
+* Each connection is owned by a supervisor which redials the endpoint with
+* jittered exponential backoff whenever the connection breaks.
+ */
+type WsPool struct {
+	Log        *zap.Logger
+	Timeout    time.Duration
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	endpoint   string
+	conns      []*wsConn
+	next       uint64
+	ids        uint64
+	closed     chan struct{}
+	closeOnce  sync.Once
+}
+
+type wsConn struct {
+	pool        *WsPool
+	index       int
+	writeMu     sync.Mutex
+	mu          sync.Mutex
+	conn        *websocket.Conn
+	pending     map[uint64]*pendingCall
+	subs        map[string]*Subscription
+	state       string
+	reconnects  int
+	connectedAt time.Time
+	lastError   error
+}
+
+// pendingCall is a request waiting for its response, sub is set for eth_subscribe requests
+type pendingCall struct {
+	ch  chan wsResult
+	sub *Subscription
+}
+
+type wsResult struct {
+	msg []byte
+	err error
+}
+
+// NewWsPool starts a supervisor for each of the size connections to endpoint
+func NewWsPool(log *zap.Logger, endpoint string, size int) *WsPool {
+	if size <= 0 {
+		size = DefaultWsPoolSize
+	}
+	p := &WsPool{
+		Log:        log,
+		Timeout:    DefaultRequestTimeout,
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		endpoint:   endpoint,
+		closed:     make(chan struct{}),
+	}
+	for i := 0; i < size; i++ {
+		c := &wsConn{pool: p, index: i, state: StateConnecting}
+		p.conns = append(p.conns, c)
+		go c.supervise()
+	}
+	return p
+}
+
+/*
+* Call sends a single JSON-RPC request body upstream and waits for the
+* matching response. The id of the request is swapped for a unique wire id
+* and restored on the response before it is returned.
+ */
+func (p *WsPool) Call(ctx context.Context, body []byte) ([]byte, error) {
+	return p.call(ctx, body, nil)
+}
+
+// call sends body on the next connected connection, registering sub from its response when set
+func (p *WsPool) call(ctx context.Context, body []byte, sub *Subscription) ([]byte, error) {
+	clientID, id, wire, err := p.prepare(body)
+	if err != nil {
+		return nil, err
+	}
+	c, ch, err := p.register(id, sub)
+	if err != nil {
+		return nil, err
+	}
+	return c.roundTrip(ctx, id, wire, ch, clientID)
+}
+
+// prepare swaps the id of a request body for a pool wide unique wire id
+func (p *WsPool) prepare(body []byte) (json.RawMessage, uint64, []byte, error) {
+	msg := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, 0, nil, err
+	}
+	clientID := msg["id"]
+	id := atomic.AddUint64(&p.ids, 1)
+	msg["id"] = json.RawMessage(strconv.FormatUint(id, 10))
+	wire, err := json.Marshal(msg)
+	return clientID, id, wire, err
+}
+
+// Status reports the state of every connection in the pool
+func (p *WsPool) Status() []apis.WsConnStatus {
+	statuses := make([]apis.WsConnStatus, 0, len(p.conns))
+	for _, c := range p.conns {
+		c.mu.Lock()
+		status := apis.WsConnStatus{
+			Connection:    c.index,
+			State:         c.state,
+			Reconnects:    c.reconnects,
+			Pending:       len(c.pending),
+			Subscriptions: len(c.subs),
+		}
+		if c.state == StateConnected {
+			status.ConnectedAt = c.connectedAt.Format(time.RFC3339)
+		}
+		if c.lastError != nil {
+			status.LastError = c.lastError.Error()
+		}
+		c.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Close stops the supervisors, sends a close frame on every connection and shuts them down
+func (p *WsPool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		for _, c := range p.conns {
+			c.mu.Lock()
+			conn := c.conn
+			c.mu.Unlock()
+			if conn != nil {
+				c.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				conn.Close()
+			}
+		}
+	})
+}
+
+// register reserves id on the next connected connection in round robin order
+func (p *WsPool) register(id uint64, sub *Subscription) (*wsConn, chan wsResult, error) {
+	start := atomic.AddUint64(&p.next, 1)
+	for i := 0; i < len(p.conns); i++ {
+		c := p.conns[(start+uint64(i))%uint64(len(p.conns))]
+		if ch, ok := c.register(id, sub); ok {
+			return c, ch, nil
+		}
+	}
+	return nil, nil, ErrNoConnection
+}
+
+func (c *wsConn) register(id uint64, sub *Subscription) (chan wsResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != StateConnected {
+		return nil, false
+	}
+	ch := make(chan wsResult, 1)
+	c.pending[id] = &pendingCall{ch: ch, sub: sub}
+	return ch, true
+}
+
+func (c *wsConn) unregister(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// call sends body on this connection only, as subscription ids are scoped to the connection that created them
+func (c *wsConn) call(ctx context.Context, body []byte) ([]byte, error) {
+	clientID, id, wire, err := c.pool.prepare(body)
+	if err != nil {
+		return nil, err
+	}
+	ch, ok := c.register(id, nil)
+	if !ok {
+		return nil, ErrConnClosed
+	}
+	return c.roundTrip(ctx, id, wire, ch, clientID)
+}
+
+// roundTrip writes a registered request and waits for its response
+func (c *wsConn) roundTrip(ctx context.Context, id uint64, wire []byte, ch chan wsResult, clientID json.RawMessage) ([]byte, error) {
+	if c.pool.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.pool.Timeout)
+		defer cancel()
+	}
+	if err := c.write(websocket.TextMessage, wire); err != nil {
+		c.unregister(id)
+		return nil, err
+	}
+
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return ReplaceID(res.msg, clientID)
+	case <-ctx.Done():
+		c.unregister(id)
+		return nil, ctx.Err()
+	}
+}
+
+func (c *wsConn) write(messageType int, msg []byte) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrConnClosed
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return conn.WriteMessage(messageType, msg)
+}
+
+// supervise keeps the connection dialed until the pool is closed
+func (c *wsConn) supervise() {
+	backoff := c.pool.MinBackoff
+	for {
+		select {
+		case <-c.pool.closed:
+			c.setState(StateClosed, nil)
+			return
+		default:
+		}
+
+		conn, _, err := websocket.DefaultDialer.Dial(c.pool.endpoint, nil)
+		if err != nil {
+			wait := jitter(backoff)
+			c.pool.Log.Error("Error dialing upstream websocket",
+				zap.Int("Connection", c.index),
+				zap.Duration("Retry", wait),
+				zap.Error(err))
+			c.setState(StateReconnecting, err)
+			select {
+			case <-c.pool.closed:
+			case <-time.After(wait):
+			}
+			backoff = c.pool.nextBackoff(backoff)
+			continue
+		}
+		backoff = c.pool.MinBackoff
+
+		c.mu.Lock()
+		c.conn = conn
+		c.pending = make(map[uint64]*pendingCall)
+		c.subs = make(map[string]*Subscription)
+		c.state = StateConnected
+		c.connectedAt = time.Now()
+		c.mu.Unlock()
+		c.pool.Log.Info("Upstream websocket connected", zap.Int("Connection", c.index))
+
+		err = c.serve(conn)
+		c.pool.Log.Error("Upstream websocket disconnected", zap.Int("Connection", c.index), zap.Error(err))
+		c.fail(err)
+	}
+}
+
+// serve reads responses from conn and keeps it alive with pings until it breaks
+func (c *wsConn) serve(conn *websocket.Conn) error {
+	done := make(chan struct{})
+	defer close(done)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	go func() {
+		ticker := time.NewTicker(pingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		c.dispatch(msg)
+	}
+}
+
+/*
+* dispatch routes an upstream message either to the caller waiting on its
+* id, or for eth_subscription notifications to the matching subscription.
+* A successful eth_subscribe response registers its subscription here in the
+* read loop, so notifications sent right after it can not be missed.
+ */
+func (c *wsConn) dispatch(msg []byte) {
+	var envelope struct {
+		ID     json.RawMessage `json:"id"`
+		Method apis.RPCCall    `json:"method"`
+		Result json.RawMessage `json:"result"`
+		Params struct {
+			Subscription string          `json:"subscription"`
+			Result       json.RawMessage `json:"result"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(msg, &envelope); err != nil {
+		c.pool.Log.Error("Error unmarshalling upstream websocket message", zap.Error(err))
+		return
+	}
+	if envelope.Method == apis.SubscriptionNotification {
+		c.mu.Lock()
+		sub := c.subs[envelope.Params.Subscription]
+		c.mu.Unlock()
+		if sub != nil {
+			sub.deliver(envelope.Params.Result)
+		}
+		return
+	}
+	id, err := strconv.ParseUint(string(envelope.ID), 10, 64)
+	if err != nil {
+		c.pool.Log.Info("Dropping upstream message without request id", zap.ByteString("Message", msg))
+		return
+	}
+	c.mu.Lock()
+	call, ok := c.pending[id]
+	delete(c.pending, id)
+	var wireID string
+	if ok && call.sub != nil && json.Unmarshal(envelope.Result, &wireID) == nil && wireID != "" {
+		c.subs[wireID] = call.sub
+		call.sub.attach(c, wireID)
+	}
+	c.mu.Unlock()
+	if ok {
+		call.ch <- wsResult{msg: msg}
+	}
+}
+
+// fail marks the connection as reconnecting and releases every waiting caller
+func (c *wsConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn = nil
+	c.state = StateReconnecting
+	c.lastError = err
+	c.reconnects++
+	for id, call := range c.pending {
+		call.ch <- wsResult{err: ErrConnClosed}
+		delete(c.pending, id)
+	}
+	for wireID, sub := range c.subs {
+		sub.detach()
+		delete(c.subs, wireID)
+		go c.pool.resubscribe(sub)
+	}
+}
+
+func (c *wsConn) setState(state string, err error) {
+	c.mu.Lock()
+	c.state = state
+	if err != nil {
+		c.lastError = err
+	}
+	c.mu.Unlock()
+}
+
+// nextBackoff doubles backoff up to MaxBackoff
+func (p *WsPool) nextBackoff(backoff time.Duration) time.Duration {
+	if backoff *= 2; backoff > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return backoff
+}
+
+// jitter returns a random duration between half of d and d
+func jitter(d time.Duration) time.Duration {
+	half := int64(d) / 2
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// ReplaceID rewrites the id member of a JSON-RPC message
+func ReplaceID(msg []byte, id json.RawMessage) ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(msg, &fields); err != nil {
+		return nil, err
+	}
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	fields["id"] = id
+	return json.Marshal(fields)
+}
@@ -0,0 +1,166 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestReplaceID(t *testing.T) {
+	for _, test := range []struct {
+		msg, id, want string
+	}{
+		{`{"jsonrpc":"2.0","id":17,"result":"0x1"}`, `"a"`, `{"id":"a","jsonrpc":"2.0","result":"0x1"}`},
+		{`{"jsonrpc":"2.0","id":17,"result":"0x1"}`, ``, `{"id":null,"jsonrpc":"2.0","result":"0x1"}`},
+		{`{"jsonrpc":"2.0","result":"0x1"}`, `{"nested":[1]}`, `{"id":{"nested":[1]},"jsonrpc":"2.0","result":"0x1"}`},
+	} {
+		var id json.RawMessage
+		if test.id != "" {
+			id = json.RawMessage(test.id)
+		}
+		got, err := ReplaceID([]byte(test.msg), id)
+		if err != nil || string(got) != test.want {
+			t.Errorf("ReplaceID(%s, %s) = %s, %v, want %s", test.msg, test.id, got, err, test.want)
+		}
+	}
+	if _, err := ReplaceID([]byte(`[1]`), json.RawMessage("1")); err == nil {
+		t.Error("ReplaceID() of a non object succeeded")
+	}
+}
+
+func TestWsPoolOutOfOrderResponses(t *testing.T) {
+	node := newFakeNode(t)
+	pool := newTestPool(t, node, 1)
+	node.hold(true)
+
+	// two callers share the client id 7, wire ids must still tell them apart
+	bodies := []string{
+		`{"jsonrpc":"2.0","id":7,"method":"eth_getBalance","params":["first"]}`,
+		`{"jsonrpc":"2.0","id":7,"method":"eth_getBalance","params":["second"]}`,
+		`{"jsonrpc":"2.0","id":"x","method":"eth_getBalance","params":["third"]}`,
+	}
+	type reply struct {
+		body string
+		resp []byte
+		err  error
+	}
+	replies := make(chan reply, len(bodies))
+	for _, body := range bodies {
+		go func(body string) {
+			resp, err := pool.Call(context.Background(), []byte(body))
+			replies <- reply{body, resp, err}
+		}(body)
+	}
+
+	var held []nodeRequest
+	wireIDs := map[string]bool{}
+	for range bodies {
+		req := <-node.requests
+		held = append(held, req)
+		wireIDs[string(req.ID)] = true
+	}
+	if len(wireIDs) != len(bodies) {
+		t.Fatalf("wire ids %v are not unique", wireIDs)
+	}
+	for i := len(held) - 1; i >= 0; i-- {
+		node.answer(held[i])
+	}
+
+	for range bodies {
+		r := <-replies
+		if r.err != nil {
+			t.Fatalf("Call(%s) error: %v", r.body, r.err)
+		}
+		var req, resp struct {
+			ID     json.RawMessage `json:"id"`
+			Params json.RawMessage `json:"params"`
+			Result json.RawMessage `json:"result"`
+		}
+		json.Unmarshal([]byte(r.body), &req)
+		json.Unmarshal(r.resp, &resp)
+		if string(resp.ID) != string(req.ID) || string(resp.Result) != string(req.Params) {
+			t.Errorf("Call(%s) = %s, want id %s and result %s", r.body, r.resp, req.ID, req.Params)
+		}
+	}
+	if pending := pool.Status()[0].Pending; pending != 0 {
+		t.Errorf("%d calls still pending after their responses", pending)
+	}
+}
+
+func TestWsPoolFailsPendingCallsOnDisconnect(t *testing.T) {
+	node := newFakeNode(t)
+	pool := newTestPool(t, node, 1)
+	node.hold(true)
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := pool.Call(context.Background(), []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`))
+			errs <- err
+		}()
+	}
+	<-node.requests
+	<-node.requests
+	node.drop()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != ErrConnClosed {
+				t.Errorf("Call() error = %v, want %v", err, ErrConnClosed)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("pending call was not failed when the connection dropped")
+		}
+	}
+
+	// the supervisor redials and the pool serves calls again
+	node.hold(false)
+	waitFor(t, "the pool to reconnect", func() bool { return connected(pool) == 1 })
+	if _, err := pool.Call(context.Background(), []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)); err != nil {
+		t.Errorf("Call() after reconnecting error: %v", err)
+	}
+	if status := pool.Status()[0]; status.Reconnects != 1 || status.Pending != 0 {
+		t.Errorf("Status() = %+v, want 1 reconnect and nothing pending", status)
+	}
+}
+
+func TestWsPoolCallTimeout(t *testing.T) {
+	node := newFakeNode(t)
+	pool := newTestPool(t, node, 1)
+	node.hold(true)
+	pool.Timeout = 50 * time.Millisecond
+
+	_, err := pool.Call(context.Background(), []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`))
+	if err != context.DeadlineExceeded {
+		t.Errorf("Call() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if pending := pool.Status()[0].Pending; pending != 0 {
+		t.Errorf("%d calls still pending after timing out", pending)
+	}
+
+	// a late response for the abandoned call is dropped
+	node.answer(<-node.requests)
+	node.hold(false)
+	pool.Timeout = DefaultRequestTimeout
+	if _, err := pool.Call(context.Background(), []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)); err != nil {
+		t.Errorf("Call() after a late response error: %v", err)
+	}
+}
+
+func TestWsPoolClose(t *testing.T) {
+	node := newFakeNode(t)
+	pool := newTestPool(t, node, 2)
+	pool.Close()
+	waitFor(t, "the connections to close", func() bool {
+		for _, status := range pool.Status() {
+			if status.State != StateClosed {
+				return false
+			}
+		}
+		return true
+	})
+	if _, err := pool.Call(context.Background(), []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`)); err != ErrNoConnection {
+		t.Errorf("Call() on a closed pool error = %v, want %v", err, ErrNoConnection)
+	}
+}
@@ -0,0 +1,276 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/jelias2/infra-test/src/apis"
+	"go.uber.org/zap"
+)
+
+const DefaultMaxAttempts = 5
+const DefaultTimeout = 10 * time.Second
+const DefaultMinBackoff = time.Second
+const DefaultMaxBackoff = time.Minute
+
+// deliveryLogSize is how many deliveries are kept per webhook
+const deliveryLogSize = 100
+
+// Delivery states reported in the delivery log
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Delivery events
+const (
+	EventBlock    = "block"
+	EventOrphaned = "orphaned"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderWebhookID = "X-Webhook-Id"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+/*
+* Dispatcher POSTs webhook payloads, retrying failed deliveries with jittered
+* exponential backoff up to MaxAttempts times. Each request is signed with
+* an HMAC-SHA256 of "<timestamp>.<body>" keyed by the webhook secret, and
+* the outcome of every delivery is kept in a bounded per webhook log. The
+* blocks delivered to each webhook are remembered, so their receivers can be
+* told when a reorg orphans them.
+ */
+type Dispatcher struct {
+	Log         *zap.Logger
+	Client      *resty.Client
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+
+	mu         sync.Mutex
+	deliveries map[string][]*apis.WebhookDelivery
+	sent       map[string][]apis.WebhookPayload
+	removed    map[string]bool
+	cancels    map[string]context.CancelFunc
+	contexts   map[string]context.Context
+}
+
+// NewDispatcher delivers through client, whose transport is replaced by one that only connects to public addresses
+func NewDispatcher(log *zap.Logger, client *resty.Client, maxAttempts int) *Dispatcher {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	return &Dispatcher{
+		Log:         log,
+		Client:      client.SetTransport(publicTransport()),
+		MaxAttempts: maxAttempts,
+		MinBackoff:  DefaultMinBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		deliveries:  make(map[string][]*apis.WebhookDelivery),
+		sent:        make(map[string][]apis.WebhookPayload),
+		removed:     make(map[string]bool),
+		cancels:     make(map[string]context.CancelFunc),
+		contexts:    make(map[string]context.Context),
+	}
+}
+
+// Dispatch queues a delivery of the matched transactions of block to w
+func (d *Dispatcher) Dispatch(w *Webhook, block *apis.BlockTxDetails, txs []apis.Transaction) {
+	payload := apis.WebhookPayload{
+		Event:        EventBlock,
+		BlockNumber:  block.Number,
+		BlockHash:    block.Hash,
+		Timestamp:    block.Timestamp,
+		Transactions: txs,
+	}
+	d.mu.Lock()
+	if d.removed[w.ID] {
+		d.mu.Unlock()
+		return
+	}
+	sent := append(d.sent[w.ID], payload)
+	if len(sent) > deliveryLogSize {
+		sent = sent[len(sent)-deliveryLogSize:]
+	}
+	d.sent[w.ID] = sent
+	d.mu.Unlock()
+	d.queue(w, payload)
+}
+
+/*
+* Orphan queues an "orphaned" delivery to w for every block delivered to it
+* whose hash is in removed, carrying the transactions delivered for the
+* block. Each block is reported once.
+ */
+func (d *Dispatcher) Orphan(w *Webhook, removed []string) {
+	orphaned := make(map[string]bool, len(removed))
+	for _, hash := range removed {
+		orphaned[hash] = true
+	}
+	var payloads []apis.WebhookPayload
+	d.mu.Lock()
+	if d.removed[w.ID] {
+		d.mu.Unlock()
+		return
+	}
+	sent := d.sent[w.ID][:0]
+	for _, payload := range d.sent[w.ID] {
+		if orphaned[payload.BlockHash] {
+			payload.Event = EventOrphaned
+			payloads = append(payloads, payload)
+			continue
+		}
+		sent = append(sent, payload)
+	}
+	d.sent[w.ID] = sent
+	d.mu.Unlock()
+	for _, payload := range payloads {
+		d.queue(w, payload)
+	}
+}
+
+// queue records a pending delivery of payload in the log of w and starts delivering it
+func (d *Dispatcher) queue(w *Webhook, payload apis.WebhookPayload) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	delivery := &apis.WebhookDelivery{
+		ID:          randomID(),
+		WebhookID:   w.ID,
+		Event:       payload.Event,
+		BlockNumber: payload.BlockNumber,
+		BlockHash:   payload.BlockHash,
+		Status:      StatusPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	for _, tx := range payload.Transactions {
+		delivery.Transactions = append(delivery.Transactions, tx.Hash)
+	}
+	payload.WebhookID, payload.DeliveryID = w.ID, delivery.ID
+	body, err := json.Marshal(payload)
+	if err != nil {
+		d.Log.Error("Error marshalling webhook payload", zap.Error(err))
+		return
+	}
+
+	d.mu.Lock()
+	// the webhook may have been removed while its block was matched
+	if d.removed[w.ID] {
+		d.mu.Unlock()
+		return
+	}
+	ctx, ok := d.contexts[w.ID]
+	if !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.Background())
+		d.contexts[w.ID], d.cancels[w.ID] = ctx, cancel
+	}
+	log := append(d.deliveries[w.ID], delivery)
+	if len(log) > deliveryLogSize {
+		log = log[len(log)-deliveryLogSize:]
+	}
+	d.deliveries[w.ID] = log
+	d.mu.Unlock()
+
+	go d.deliver(ctx, w, delivery, body)
+}
+
+// deliver POSTs body until the receiver answers 2xx, attempts run out or the webhook is removed
+func (d *Dispatcher) deliver(ctx context.Context, w *Webhook, delivery *apis.WebhookDelivery, body []byte) {
+	backoff := d.MinBackoff
+	for attempt := 1; ; attempt++ {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		resp, err := d.Client.R().
+			SetContext(ctx).
+			SetHeader("Content-Type", "application/json").
+			SetHeader(HeaderWebhookID, w.ID).
+			SetHeader(HeaderDelivery, delivery.ID).
+			SetHeader(HeaderTimestamp, timestamp).
+			SetHeader(HeaderSignature, "sha256="+Sign(w.Secret, timestamp, body)).
+			SetBody(body).
+			Post(w.URL)
+		if err == nil && resp.IsError() {
+			err = fmt.Errorf("webhook receiver returned %s", resp.Status())
+		}
+
+		d.mu.Lock()
+		delivery.Attempts = attempt
+		delivery.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		if resp != nil {
+			delivery.StatusCode = resp.StatusCode()
+		}
+		if err == nil {
+			delivery.Status, delivery.LastError = StatusDelivered, ""
+		} else {
+			delivery.LastError = err.Error()
+			if attempt >= d.MaxAttempts || ctx.Err() != nil {
+				delivery.Status = StatusFailed
+			}
+		}
+		status := delivery.Status
+		d.mu.Unlock()
+
+		if status != StatusPending {
+			if status == StatusFailed {
+				d.Log.Error("Webhook delivery failed", zap.String("Webhook", w.ID), zap.String("Delivery", delivery.ID), zap.Int("Attempts", attempt), zap.Error(err))
+			}
+			return
+		}
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > d.MaxBackoff {
+			backoff = d.MaxBackoff
+		}
+	}
+}
+
+// Deliveries returns the delivery log of a webhook, most recent first
+func (d *Dispatcher) Deliveries(webhookID string) []apis.WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	log := d.deliveries[webhookID]
+	deliveries := make([]apis.WebhookDelivery, 0, len(log))
+	for i := len(log) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, *log[i])
+	}
+	return deliveries
+}
+
+// Forget stops the pending retries of a removed webhook, drops its delivery log and delivered blocks and refuses its later deliveries
+func (d *Dispatcher) Forget(webhookID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cancel, ok := d.cancels[webhookID]; ok {
+		cancel()
+	}
+	delete(d.cancels, webhookID)
+	delete(d.contexts, webhookID)
+	delete(d.deliveries, webhookID)
+	delete(d.sent, webhookID)
+	d.removed[webhookID] = true
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>" keyed by secret
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
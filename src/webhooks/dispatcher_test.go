@@ -0,0 +1,86 @@
+package webhooks
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/jelias2/infra-test/src/apis"
+	"go.uber.org/zap"
+)
+
+// newTestDispatcher delivers to local test servers, which the public only transport refuses
+func newTestDispatcher() *Dispatcher {
+	d := NewDispatcher(zap.NewNop(), resty.New(), 1)
+	d.Client.SetTransport(http.DefaultTransport)
+	return d
+}
+
+func TestDispatcherRefusesInternalAddresses(t *testing.T) {
+	var hits int64
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { atomic.AddInt64(&hits, 1) }))
+	defer receiver.Close()
+	d := NewDispatcher(zap.NewNop(), resty.New(), 1)
+	w := &Webhook{ID: "w", URL: receiver.URL, Secret: "s"}
+	d.Dispatch(w, &apis.BlockTxDetails{BlockHeader: apis.BlockHeader{Number: "0x1", Hash: "0xa1"}}, []apis.Transaction{{Hash: "0x01"}})
+	delivery := waitDelivery(t, d, "w")
+	if delivery.Status != StatusFailed || atomic.LoadInt64(&hits) != 0 {
+		t.Errorf("delivery to %s = %+v, want it refused before connecting", receiver.URL, delivery)
+	}
+}
+
+func TestDispatcherOrphan(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+	d := newTestDispatcher()
+	w := &Webhook{ID: "w", URL: receiver.URL, Secret: "s"}
+	for _, hash := range []string{"0xa1", "0xa2"} {
+		d.Dispatch(w, &apis.BlockTxDetails{BlockHeader: apis.BlockHeader{Number: "0x1", Hash: hash}}, []apis.Transaction{{Hash: "0x01"}})
+	}
+	d.Orphan(w, []string{"0xa2", "0xb2"})
+	d.Orphan(w, []string{"0xa2"})
+
+	deliveries := d.Deliveries("w")
+	if len(deliveries) != 3 {
+		t.Fatalf("got %d deliveries, want 2 blocks and 1 orphaned", len(deliveries))
+	}
+	orphaned := deliveries[0]
+	if orphaned.Event != EventOrphaned || orphaned.BlockHash != "0xa2" || len(orphaned.Transactions) != 1 {
+		t.Errorf("latest delivery = %+v, want block 0xa2 orphaned with its transaction", orphaned)
+	}
+}
+
+func TestDispatcherDropsDeliveriesAfterForget(t *testing.T) {
+	var hits int64
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { atomic.AddInt64(&hits, 1) }))
+	defer receiver.Close()
+	d := newTestDispatcher()
+	w := &Webhook{ID: "w", URL: receiver.URL, Secret: "s"}
+	d.Forget(w.ID)
+	d.Dispatch(w, &apis.BlockTxDetails{BlockHeader: apis.BlockHeader{Number: "0x1", Hash: "0xa1"}}, []apis.Transaction{{Hash: "0x01"}})
+	d.Orphan(w, []string{"0xa1"})
+	time.Sleep(50 * time.Millisecond)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if requests := atomic.LoadInt64(&hits); len(d.contexts) != 0 || len(d.cancels) != 0 || len(d.deliveries) != 0 || len(d.sent) != 0 || requests != 0 {
+		t.Errorf("a removed webhook got a delivery: %d requests, %d logs, %d sent blocks", requests, len(d.deliveries), len(d.sent))
+	}
+}
+
+// waitDelivery waits for the first delivery of webhookID to finish
+func waitDelivery(t *testing.T, d *Dispatcher, webhookID string) apis.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if deliveries := d.Deliveries(webhookID); len(deliveries) > 0 && deliveries[0].Status != StatusPending {
+			return deliveries[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("delivery of %s did not finish", webhookID)
+	return apis.WebhookDelivery{}
+}
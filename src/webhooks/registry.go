@@ -0,0 +1,61 @@
+package webhooks
+
+import (
+	"sort"
+	"sync"
+)
+
+// Registry holds the registered webhooks in memory
+type Registry struct {
+	mu    sync.RWMutex
+	hooks map[string]*Webhook
+}
+
+func NewRegistry() *Registry {
+	return &Registry{hooks: make(map[string]*Webhook)}
+}
+
+func (r *Registry) Add(w *Webhook) {
+	r.mu.Lock()
+	r.hooks[w.ID] = w
+	r.mu.Unlock()
+}
+
+func (r *Registry) Get(id string) (*Webhook, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	w, ok := r.hooks[id]
+	return w, ok
+}
+
+// Remove deletes the webhook with id, reporting whether it existed
+func (r *Registry) Remove(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.hooks[id]
+	delete(r.hooks, id)
+	return ok
+}
+
+// List returns every webhook ordered by creation time
+func (r *Registry) List() []*Webhook {
+	r.mu.RLock()
+	hooks := make([]*Webhook, 0, len(r.hooks))
+	for _, w := range r.hooks {
+		hooks = append(hooks, w)
+	}
+	r.mu.RUnlock()
+	sort.Slice(hooks, func(i, j int) bool {
+		if hooks[i].CreatedAt.Equal(hooks[j].CreatedAt) {
+			return hooks[i].ID < hooks[j].ID
+		}
+		return hooks[i].CreatedAt.Before(hooks[j].CreatedAt)
+	})
+	return hooks
+}
+
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.hooks)
+}
@@ -0,0 +1,109 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+var ErrForbiddenURL = errors.New("url must not point to a loopback, private, link-local or otherwise internal address")
+var ErrUnresolvableURL = errors.New("url host could not be resolved")
+
+// lookupTimeout bounds the DNS lookup of a webhook host at registration
+const lookupTimeout = 5 * time.Second
+
+// internalNetworks are the address ranges webhooks may not be delivered to, besides loopback, link-local, multicast and unspecified addresses
+var internalNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"fc00::/7",
+	// NAT64 and 6to4 translate to IPv4 addresses, which may be internal
+	"64:ff9b::/96",
+	"2002::/16",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// PublicIP reports whether ip may receive webhook deliveries
+func PublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range internalNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkHost resolves host and fails unless every address it resolves to is public
+func checkHost(host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !PublicIP(ip) {
+			return ErrForbiddenURL
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return ErrUnresolvableURL
+	}
+	for _, addr := range addrs {
+		if !PublicIP(addr.IP) {
+			return ErrForbiddenURL
+		}
+	}
+	return nil
+}
+
+/*
+* publicOnly is a dialer Control function refusing connections to internal
+* addresses. It runs on the resolved address of every connection, so a host
+* that resolves to an internal address after registration, or a redirect to
+* one, is refused as well.
+ */
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !PublicIP(net.ParseIP(host)) {
+		return ErrForbiddenURL
+	}
+	return nil
+}
+
+// publicTransport is an HTTP transport that only connects to public addresses and ignores proxy settings
+func publicTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   publicOnly,
+	}).DialContext
+	return transport
+}
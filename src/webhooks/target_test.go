@@ -0,0 +1,68 @@
+package webhooks
+
+import (
+	"testing"
+
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+func TestCheckHost(t *testing.T) {
+	for _, test := range []struct {
+		host string
+		err  error
+	}{
+		{"8.8.8.8", nil},
+		{"2001:4860:4860::8888", nil},
+		{"127.0.0.1", ErrForbiddenURL},
+		{"::1", ErrForbiddenURL},
+		{"localhost", ErrForbiddenURL},
+		{"169.254.169.254", ErrForbiddenURL},
+		{"fe80::1", ErrForbiddenURL},
+		{"10.1.2.3", ErrForbiddenURL},
+		{"172.16.0.1", ErrForbiddenURL},
+		{"192.168.1.1", ErrForbiddenURL},
+		{"100.64.0.1", ErrForbiddenURL},
+		{"fd00::1", ErrForbiddenURL},
+		{"0.0.0.0", ErrForbiddenURL},
+		{"::", ErrForbiddenURL},
+		{"224.0.0.1", ErrForbiddenURL},
+		{"::ffff:127.0.0.1", ErrForbiddenURL},
+		{"64:ff9b::a9fe:a9fe", ErrForbiddenURL},
+		{"2002:a9fe:a9fe::1", ErrForbiddenURL},
+		{"host.invalid", ErrUnresolvableURL},
+	} {
+		if err := checkHost(test.host); err != test.err {
+			t.Errorf("checkHost(%q) = %v, want %v", test.host, err, test.err)
+		}
+	}
+}
+
+func TestPublicOnly(t *testing.T) {
+	for _, test := range []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:8080", false},
+		{"169.254.169.254:80", false},
+		{"10.0.0.1:80", false},
+		{"[::1]:80", false},
+		{"[64:ff9b::7f00:1]:80", false},
+		{"[2002:7f00:1::]:80", false},
+		{"not an address", false},
+	} {
+		if err := publicOnly("tcp", test.address, nil); (err == nil) != test.allowed {
+			t.Errorf("publicOnly(%q) = %v, want allowed %v", test.address, err, test.allowed)
+		}
+	}
+}
+
+func TestNewWebhookRejectsInternalURL(t *testing.T) {
+	for _, url := range []string{"http://127.0.0.1:8080/hook", "http://169.254.169.254/latest/meta-data", "http://[::1]/hook", "http://localhost/hook"} {
+		_, err := NewWebhook(apis.WebhookRequest{URL: url, Addresses: []string{"0xea674fdde714fd979de3edf0f56aa9716b898ec8"}})
+		if err != ErrForbiddenURL {
+			t.Errorf("NewWebhook(%q) = %v, want %v", url, err, ErrForbiddenURL)
+		}
+	}
+}
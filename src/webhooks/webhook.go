@@ -0,0 +1,152 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jelias2/infra-test/src/apis"
+)
+
+// ERC-20 method selectors whose arguments name the token sender and recipient
+const transferSelector = "0xa9059cbb"
+const transferFromSelector = "0x23b872dd"
+
+var addressPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+
+var ErrInvalidURL = errors.New("url must be an absolute http or https url")
+var ErrNoAddresses = errors.New("at least one address is required")
+var ErrInvalidAddress = errors.New("addresses must be 20 byte hex strings starting with 0x")
+var ErrInvalidToken = errors.New("token must be a 20 byte hex contract address starting with 0x")
+
+/*
+* Webhook is a registered receiver of transaction notifications. Without a
+* Token it matches transactions sent from or to one of its addresses, with a
+* Token it matches ERC-20 transfers of that contract whose sender or
+* recipient is one of its addresses.
+ */
+type Webhook struct {
+	ID        string
+	URL       string
+	Addresses map[string]bool
+	Token     string
+	Secret    string
+	CreatedAt time.Time
+	// After is the newest block followed before registration, older blocks are not delivered
+	After uint64
+}
+
+/*
+* NewWebhook validates a registration request, generating a secret when none
+* was given. The url must resolve to public addresses only, deliveries are
+* checked again when connecting.
+ */
+func NewWebhook(req apis.WebhookRequest) (*Webhook, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+	if len(req.Addresses) == 0 {
+		return nil, ErrNoAddresses
+	}
+	w := &Webhook{
+		ID:        randomID(),
+		URL:       req.URL,
+		Addresses: make(map[string]bool, len(req.Addresses)),
+		Secret:    req.Secret,
+		CreatedAt: time.Now().UTC(),
+	}
+	for _, address := range req.Addresses {
+		if !addressPattern.MatchString(address) {
+			return nil, ErrInvalidAddress
+		}
+		w.Addresses[strings.ToLower(address)] = true
+	}
+	if req.Token != "" {
+		if !addressPattern.MatchString(req.Token) {
+			return nil, ErrInvalidToken
+		}
+		w.Token = strings.ToLower(req.Token)
+	}
+	if err := checkHost(u.Hostname()); err != nil {
+		return nil, err
+	}
+	if w.Secret == "" {
+		w.Secret = randomID() + randomID()
+	}
+	return w, nil
+}
+
+// Match returns the transactions of block relevant to the webhook, none for blocks followed before it was registered
+func (w *Webhook) Match(block *apis.BlockTxDetails) []apis.Transaction {
+	if number, ok := apis.ParseBlockNumber(block.Number); !ok || number <= w.After {
+		return nil
+	}
+	var matched []apis.Transaction
+	for _, tx := range block.Transactions {
+		if w.matches(tx) {
+			matched = append(matched, tx)
+		}
+	}
+	return matched
+}
+
+func (w *Webhook) matches(tx apis.Transaction) bool {
+	if w.Token == "" {
+		return w.Addresses[strings.ToLower(tx.From)] || w.Addresses[strings.ToLower(tx.To)]
+	}
+	if strings.ToLower(tx.To) != w.Token {
+		return false
+	}
+	for _, address := range transferParties(tx) {
+		if w.Addresses[address] {
+			return true
+		}
+	}
+	return false
+}
+
+// transferParties returns the sender and recipient of an ERC-20 transfer or transferFrom call
+func transferParties(tx apis.Transaction) []string {
+	input := strings.ToLower(tx.Input)
+	switch {
+	case strings.HasPrefix(input, transferSelector) && len(input) >= 10+64:
+		return []string{strings.ToLower(tx.From), argAddress(input, 0)}
+	case strings.HasPrefix(input, transferFromSelector) && len(input) >= 10+128:
+		return []string{argAddress(input, 0), argAddress(input, 1)}
+	}
+	return nil
+}
+
+// argAddress decodes the address argument at index from hex encoded call data
+func argAddress(input string, index int) string {
+	word := input[10+index*64 : 10+(index+1)*64]
+	return "0x" + word[24:]
+}
+
+// View is the API representation of the webhook, the secret is left out
+func (w *Webhook) View() apis.Webhook {
+	view := apis.Webhook{
+		ID:        w.ID,
+		URL:       w.URL,
+		Addresses: make([]string, 0, len(w.Addresses)),
+		Token:     w.Token,
+		CreatedAt: w.CreatedAt.Format(time.RFC3339),
+	}
+	for address := range w.Addresses {
+		view.Addresses = append(view.Addresses, address)
+	}
+	sort.Strings(view.Addresses)
+	return view
+}
+
+func randomID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}